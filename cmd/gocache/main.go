@@ -35,6 +35,7 @@ func run(args []string) error {
 	configPath := flag.String("config", "", "Path to config file")
 	daemon := flag.Bool("daemon", false, "Run as a background daemon")
 	logLevel := flag.String("log-level", "", "Log level (debug, info, warn, error)")
+	namespace := flag.String("namespace", "", "Cache namespace for management commands")
 	flag.Parse()
 
 	if len(flag.Args()) > 0 {
@@ -42,7 +43,11 @@ func run(args []string) error {
 		if err != nil {
 			return fmt.Errorf("error loading config for CLI: %w", err)
 		}
-		return cli.Run(cfg.Server.ControlPort, flag.Args())
+		cliArgs := flag.Args()
+		if *namespace != "" {
+			cliArgs = append([]string{"--namespace", *namespace}, cliArgs...)
+		}
+		return cli.Run(cfg.Server.ControlPort, cliArgs)
 	}
 
 	if *daemon {
@@ -145,9 +150,25 @@ func startServer(configPath, logLevelOverride string, testShutdown ...func()) {
 	}
 	logger.Debug("proxy server created successfully")
 
+	nsCaches := make(map[string]*cache.MemoryCache, len(cfg.Namespaces))
+	for name, ns := range cfg.Namespaces {
+		nc := cache.NewMemoryCache(ns.GetDefaultTTL(&cfg.Cache), ns.GetMaxSizeMB(&cfg.Cache))
+		if cfg.Persistence.Enable {
+			file := ns.GetCacheFile(name, &cfg.Persistence)
+			if err := nc.LoadFromFile(file); err != nil && !os.IsNotExist(err) {
+				logger.Warn("failed to load namespace cache from file", "namespace", name, "error", err)
+			}
+		}
+		p.Namespaces().Add(name, nc)
+		nsCaches[name] = nc
+		logger.Debug("namespace cache created", "namespace", name, "defaultTTL", ns.GetDefaultTTL(&cfg.Cache), "maxSizeMB", ns.GetMaxSizeMB(&cfg.Cache))
+	}
+
 	shutdown := func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		// Stops the namespace listeners too, so no request writes to a cache
+		// while it is being saved
 		if err := p.Shutdown(ctx); err != nil {
 			logger.Error("proxy shutdown failed", "error", err)
 		}
@@ -155,6 +176,11 @@ func startServer(configPath, logLevelOverride string, testShutdown ...func()) {
 			if err := c.SaveToFile(cfg.Persistence.CacheFile); err != nil {
 				logger.Error("failed to save cache to file", "error", err)
 			}
+			for name, nc := range nsCaches {
+				if err := nc.SaveToFile(cfg.Namespaces[name].GetCacheFile(name, &cfg.Persistence)); err != nil {
+					logger.Error("failed to save namespace cache to file", "namespace", name, "error", err)
+				}
+			}
 		}
 		if len(testShutdown) > 0 {
 			testShutdown[0]()
@@ -174,6 +200,18 @@ func startServer(configPath, logLevelOverride string, testShutdown ...func()) {
 
 	handleSignals(logger, shutdown, controlAPI.ReloadConfig)
 
+	for name, ns := range cfg.Namespaces {
+		if ns.ProxyPort == 0 {
+			continue
+		}
+		nsAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, ns.ProxyPort)
+		go func() {
+			if err := p.StartNamespaceListener(name, nsAddr); err != nil && err != http.ErrServerClosed {
+				logger.Error("namespace proxy listener failed", "namespace", name, "error", err)
+			}
+		}()
+	}
+
 	addr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.ProxyPort)
 	logger.Info("GoCache starting", "address", addr)
 	logger.Debug("server configuration", "proxyPort", cfg.Server.ProxyPort, "controlPort", cfg.Server.ControlPort, "appLevel", appLevel)
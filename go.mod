@@ -2,4 +2,9 @@ module github.com/gbmerrall/gocache
 
 go 1.25.0
 
-require github.com/BurntSushi/toml v1.5.0
+require (
+	github.com/BurntSushi/toml v1.5.0
+	golang.org/x/net v0.47.0
+)
+
+require golang.org/x/text v0.31.0 // indirect
@@ -17,15 +17,35 @@ import (
 type cacheNode struct {
 	key   string
 	entry CacheEntry
-	size  int64 // Body size for this entry
+	size  int64 // Body size for this entry, including encoded copies
 }
 
+// NoExpiry can be passed to SetWithTTL to store an entry that never expires.
+const NoExpiry time.Duration = -1
+
 // CacheEntry represents a single cached HTTP response.
 type CacheEntry struct {
 	StatusCode int
 	Headers    http.Header
 	Body       []byte
-	Expiry     time.Time
+	Encodings  map[string][]byte // Encoded copies of Body, by content coding
+	Expiry     time.Time         // Zero means the entry never expires
+	StoredAt   time.Time         // When the entry was written to the cache
+	Hits       uint64            // Number of times the entry has been served from the cache
+}
+
+// Size returns the number of bytes the entry's bodies take up.
+func (e CacheEntry) Size() int64 {
+	size := int64(len(e.Body))
+	for _, data := range e.Encodings {
+		size += int64(len(data))
+	}
+	return size
+}
+
+// IsExpired reports whether the entry has expired at the given time.
+func (e CacheEntry) IsExpired(now time.Time) bool {
+	return !e.Expiry.IsZero() && now.After(e.Expiry)
 }
 
 // CacheStats holds statistics about the cache's performance.
@@ -42,10 +62,11 @@ type CacheStats struct {
 // MemoryCache is a thread-safe in-memory cache for HTTP responses with LRU eviction.
 type MemoryCache struct {
 	mu          sync.RWMutex
-	items       map[string]*list.Element // Maps key -> list element
-	lruList     *list.List               // Doubly-linked list for LRU order (head=recent, tail=old)
-	currentSize int64                    // Total size of all cached bodies in bytes
-	maxSize     int64                    // Maximum cache size in bytes (0 = unlimited)
+	items       map[string]*list.Element       // Maps key -> list element
+	variants    map[string]map[string]struct{} // Maps base key -> keys of its "#" variants
+	lruList     *list.List                     // Doubly-linked list for LRU order (head=recent, tail=old)
+	currentSize int64                          // Total size of all cached bodies in bytes
+	maxSize     int64                          // Maximum cache size in bytes (0 = unlimited)
 	defaultTTL  time.Duration
 	startTime   time.Time
 	hits        atomic.Uint64
@@ -59,6 +80,7 @@ type MemoryCache struct {
 func NewMemoryCache(defaultTTL time.Duration, maxSizeMB int) *MemoryCache {
 	c := &MemoryCache{
 		items:       make(map[string]*list.Element),
+		variants:    make(map[string]map[string]struct{}),
 		lruList:     list.New(),
 		maxSize:     int64(maxSizeMB) * 1024 * 1024,
 		defaultTTL:  defaultTTL,
@@ -71,6 +93,13 @@ func NewMemoryCache(defaultTTL time.Duration, maxSizeMB int) *MemoryCache {
 
 // Get retrieves a CacheEntry from the cache and marks it as recently used.
 func (c *MemoryCache) Get(key string) (CacheEntry, bool) {
+	return c.GetAcceptable(key, 0, nil)
+}
+
+// GetAcceptable retrieves a CacheEntry like Get, but also returns entries that
+// expired no more than maxStale ago (any expired entry still held if maxStale
+// is negative). If accept is non-nil, entries it rejects are treated as misses.
+func (c *MemoryCache) GetAcceptable(key string, maxStale time.Duration, accept func(CacheEntry) bool) (CacheEntry, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -83,18 +112,41 @@ func (c *MemoryCache) Get(key string) (CacheEntry, bool) {
 	node := elem.Value.(*cacheNode)
 
 	// Check if expired
-	if time.Now().After(node.entry.Expiry) {
+	now := time.Now()
+	if node.entry.IsExpired(now) && maxStale >= 0 && now.After(node.entry.Expiry.Add(maxStale)) {
 		c.removeElement(elem)
 		c.misses.Add(1)
 		return CacheEntry{}, false
 	}
 
+	if accept != nil && !accept(node.entry) {
+		c.misses.Add(1)
+		return CacheEntry{}, false
+	}
+
 	// Move to front (mark as recently used)
 	c.lruList.MoveToFront(elem)
 	c.hits.Add(1)
+	node.entry.Hits++
 	return node.entry, true
 }
 
+// Peek returns the entry for key without updating LRU order or statistics.
+func (c *MemoryCache) Peek(key string) (CacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	elem, found := c.items[key]
+	if !found {
+		return CacheEntry{}, false
+	}
+	entry := elem.Value.(*cacheNode).entry
+	if entry.IsExpired(time.Now()) {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
 // removeElement removes an element from both the list and map.
 // Must be called with lock held.
 func (c *MemoryCache) removeElement(elem *list.Element) {
@@ -102,6 +154,31 @@ func (c *MemoryCache) removeElement(elem *list.Element) {
 	c.lruList.Remove(elem)
 	delete(c.items, node.key)
 	c.currentSize -= node.size
+	if base, ok := variantBase(node.key); ok {
+		delete(c.variants[base], node.key)
+		if len(c.variants[base]) == 0 {
+			delete(c.variants, base)
+		}
+	}
+}
+
+// addElement stores node at the front of the LRU list and indexes it.
+// Must be called with lock held.
+func (c *MemoryCache) addElement(node *cacheNode) {
+	c.items[node.key] = c.lruList.PushFront(node)
+	c.currentSize += node.size
+	if base, ok := variantBase(node.key); ok {
+		if c.variants[base] == nil {
+			c.variants[base] = make(map[string]struct{})
+		}
+		c.variants[base][node.key] = struct{}{}
+	}
+}
+
+// variantBase returns the part of key before its first "#", if it has one.
+func variantBase(key string) (string, bool) {
+	base, _, found := strings.Cut(key, "#")
+	return base, found
 }
 
 // evictLRU removes the least recently used entry from the cache.
@@ -138,11 +215,12 @@ func (c *MemoryCache) Set(key string, entry CacheEntry) {
 }
 
 // SetWithTTL adds a CacheEntry to the cache with a custom TTL and size enforcement.
+// A ttl of NoExpiry stores the entry until it is purged or evicted.
 func (c *MemoryCache) SetWithTTL(key string, entry CacheEntry, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	entrySize := int64(len(entry.Body))
+	entrySize := entry.Size()
 
 	// Check if single entry exceeds max size
 	if c.maxSize > 0 && entrySize > c.maxSize {
@@ -159,15 +237,57 @@ func (c *MemoryCache) SetWithTTL(key string, entry CacheEntry, ttl time.Duration
 	c.evictUntilSize(entrySize)
 
 	// Add new entry to front of list
-	entry.Expiry = time.Now().Add(ttl)
+	entry.StoredAt = time.Now()
+	if ttl == NoExpiry {
+		entry.Expiry = time.Time{}
+	} else {
+		entry.Expiry = entry.StoredAt.Add(ttl)
+	}
 	node := &cacheNode{
 		key:   key,
 		entry: entry,
 		size:  entrySize,
 	}
-	elem := c.lruList.PushFront(node)
-	c.items[key] = elem
-	c.currentSize += entrySize
+	c.addElement(node)
+}
+
+// AddEncoding adds an encoded copy of the body of the entry stored under key
+// at storedAt. It does nothing if the entry has since been replaced, or if
+// the copy would not fit in the cache.
+func (c *MemoryCache) AddEncoding(key, coding string, data []byte, storedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.items[key]
+	if !exists {
+		return
+	}
+	node := elem.Value.(*cacheNode)
+	if !node.entry.StoredAt.Equal(storedAt) {
+		return
+	}
+	if _, ok := node.entry.Encodings[coding]; ok {
+		return
+	}
+	size := int64(len(data))
+	if c.maxSize > 0 && node.size+size > c.maxSize {
+		return
+	}
+
+	// Copy the map, since entries handed out by Get share it
+	encodings := make(map[string][]byte, len(node.entry.Encodings)+1)
+	for k, v := range node.entry.Encodings {
+		encodings[k] = v
+	}
+	encodings[coding] = data
+	node.entry.Encodings = encodings
+
+	// The entry fits on its own, so moving it to the front keeps it out of
+	// the eviction below
+	c.lruList.MoveToFront(elem)
+	node.size += size
+	c.currentSize += size
+	c.evictUntilSize(0)
 }
 
 // delete removes an entry from the cache.
@@ -261,17 +381,18 @@ func (c *MemoryCache) LoadFromFile(filename string) error {
 	defer c.mu.Unlock()
 
 	c.items = make(map[string]*list.Element)
+	c.variants = make(map[string]map[string]struct{})
 	c.lruList = list.New()
 	c.currentSize = 0
 
 	// Add all entries (oldest first, so most recent end up at front)
 	for key, entry := range tempItems {
 		// Skip expired entries
-		if time.Now().After(entry.Expiry) {
+		if entry.IsExpired(time.Now()) {
 			continue
 		}
 
-		entrySize := int64(len(entry.Body))
+		entrySize := entry.Size()
 
 		// Skip entries that are too large
 		if c.maxSize > 0 && entrySize > c.maxSize {
@@ -287,9 +408,7 @@ func (c *MemoryCache) LoadFromFile(filename string) error {
 			entry: entry,
 			size:  entrySize,
 		}
-		elem := c.lruList.PushFront(node)
-		c.items[key] = elem
-		c.currentSize += entrySize
+		c.addElement(node)
 	}
 
 	return nil
@@ -302,6 +421,7 @@ func (c *MemoryCache) PurgeAll() int {
 
 	count := len(c.items)
 	c.items = make(map[string]*list.Element)
+	c.variants = make(map[string]map[string]struct{})
 	c.lruList = list.New()
 	c.currentSize = 0
 	c.hits.Store(0)
@@ -322,6 +442,30 @@ func (c *MemoryCache) PurgeByURL(rawURL string) bool {
 	return found
 }
 
+// PurgeVariants removes the entry stored under key and every variant of it,
+// whose key is key followed by a "#" fragment. It returns the number of
+// entries removed.
+func (c *MemoryCache) PurgeVariants(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	if elem, found := c.items[key]; found {
+		c.removeElement(elem)
+		count++
+	}
+	// Variant keys are indexed by the text before their first "#", which is
+	// key itself unless key already holds a fragment
+	base, _ := variantBase(key)
+	for k := range c.variants[base] {
+		if strings.HasPrefix(k, key+"#") {
+			c.removeElement(c.items[k])
+			count++
+		}
+	}
+	return count
+}
+
 // PurgeByDomain removes all entries belonging to a specific domain.
 func (c *MemoryCache) PurgeByDomain(domain string) int {
 	c.mu.Lock()
@@ -373,7 +517,7 @@ func (c *MemoryCache) removeExpiredEntries() {
 
 	for _, elem := range c.items {
 		node := elem.Value.(*cacheNode)
-		if now.After(node.entry.Expiry) {
+		if node.entry.IsExpired(now) {
 			elemsToDelete = append(elemsToDelete, elem)
 		}
 	}
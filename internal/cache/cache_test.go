@@ -121,6 +121,53 @@ func TestMemoryCache(t *testing.T) {
 		}
 	})
 
+	t.Run("PurgeVariants", func(t *testing.T) {
+		c := NewMemoryCache(1*time.Minute, 0)
+		entry := CacheEntry{
+			StatusCode: http.StatusOK,
+			Body:       []byte("test"),
+		}
+		c.Set("https://example.com/page", entry)
+		c.Set("https://example.com/page#h:accept-language=en", entry)
+		c.Set("https://example.com/page2", entry)
+
+		count := c.PurgeVariants("https://example.com/page")
+		if count != 2 {
+			t.Errorf("expected to purge 2 items, got %d", count)
+		}
+		if _, ok := c.Get("https://example.com/page#h:accept-language=en"); ok {
+			t.Error("expected the variant to be purged")
+		}
+		if _, ok := c.Get("https://example.com/page2"); !ok {
+			t.Error("expected page2 to remain")
+		}
+		if len(c.variants) != 0 {
+			t.Errorf("expected the variant index to be empty, got %v", c.variants)
+		}
+
+		// Variants restored from disk are indexed too
+		c.Set("https://example.com/page#h:accept-language=fr", entry)
+		c.Set("https://example.com/page#h:accept-language=fr#gzip", entry)
+		filename := filepath.Join(t.TempDir(), "cache.gob")
+		if err := c.SaveToFile(filename); err != nil {
+			t.Fatalf("failed to save cache: %v", err)
+		}
+		loaded := NewMemoryCache(1*time.Minute, 0)
+		defer loaded.Shutdown()
+		if err := loaded.LoadFromFile(filename); err != nil {
+			t.Fatalf("failed to load cache: %v", err)
+		}
+		if count := loaded.PurgeVariants("https://example.com/page#h:accept-language=fr"); count != 2 {
+			t.Errorf("expected to purge 2 loaded variants, got %d", count)
+		}
+		if count := loaded.PurgeVariants("https://example.com/page"); count != 0 {
+			t.Errorf("expected nothing left to purge, got %d", count)
+		}
+		if len(loaded.variants) != 0 {
+			t.Errorf("expected the variant index to be empty, got %v", loaded.variants)
+		}
+	})
+
 	t.Run("PurgeByDomain", func(t *testing.T) {
 		c := NewMemoryCache(1*time.Minute, 0)
 		entry := CacheEntry{
@@ -617,3 +664,165 @@ func TestMemoryCache_PersistenceWithLRU(t *testing.T) {
 		t.Errorf("Expected size %d, got %d", expectedSize, stats.TotalSize)
 	}
 }
+
+func TestMemoryCache_NoExpiry(t *testing.T) {
+	c := NewMemoryCache(1*time.Millisecond, 0)
+	defer c.Shutdown()
+
+	c.SetWithTTL("pinned", CacheEntry{StatusCode: http.StatusOK, Body: []byte("fixture")}, NoExpiry)
+	time.Sleep(5 * time.Millisecond)
+
+	got, ok := c.Get("pinned")
+	if !ok {
+		t.Fatal("expected entry with NoExpiry to survive past default TTL")
+	}
+	if !got.Expiry.IsZero() {
+		t.Errorf("expected zero expiry, got %v", got.Expiry)
+	}
+
+	c.removeExpiredEntries()
+	if _, ok := c.Get("pinned"); !ok {
+		t.Error("background cleanup removed entry with NoExpiry")
+	}
+}
+
+func TestMemoryCache_HitTracking(t *testing.T) {
+	c := NewMemoryCache(1*time.Hour, 0)
+	defer c.Shutdown()
+
+	before := time.Now()
+	c.Set("key", CacheEntry{StatusCode: http.StatusOK, Body: []byte("x")})
+
+	for i := 1; i <= 3; i++ {
+		got, ok := c.Get("key")
+		if !ok {
+			t.Fatal("expected entry to be found")
+		}
+		if got.Hits != uint64(i) {
+			t.Errorf("after %d gets, got Hits %d", i, got.Hits)
+		}
+		if got.StoredAt.Before(before) || got.Expiry.Sub(got.StoredAt) != time.Hour {
+			t.Errorf("unexpected StoredAt %v / Expiry %v", got.StoredAt, got.Expiry)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	def := NewMemoryCache(time.Minute, 0)
+	r := NewRegistry(def)
+	defer r.Shutdown()
+
+	if r.Default() != def {
+		t.Error("expected Default to return the default cache")
+	}
+	if c, ok := r.Get(""); !ok || c != def {
+		t.Error("expected empty name to select the default cache")
+	}
+	if _, ok := r.Get("project"); ok {
+		t.Error("expected unknown namespace to be missing")
+	}
+
+	project := NewMemoryCache(time.Minute, 1)
+	r.Add("project", project)
+	project.Set("key", CacheEntry{StatusCode: http.StatusOK, Body: []byte("x")})
+	if _, ok := def.Get("key"); ok {
+		t.Error("namespaces must not share entries")
+	}
+	if c, ok := r.Get("project"); !ok || c != project {
+		t.Error("expected registered namespace to be returned")
+	}
+
+	names := r.Names()
+	if len(names) != 2 || names[0] != DefaultNamespace || names[1] != "project" {
+		t.Errorf("got names %v", names)
+	}
+}
+
+func TestMemoryCache_Peek(t *testing.T) {
+	c := NewMemoryCache(time.Hour, 0)
+	defer c.Shutdown()
+
+	if _, ok := c.Peek("missing"); ok {
+		t.Error("expected missing key not to be found")
+	}
+	c.Set("key", CacheEntry{StatusCode: http.StatusOK, Body: []byte("x")})
+	if _, ok := c.Peek("key"); !ok {
+		t.Fatal("expected entry to be found")
+	}
+	stats := c.GetStats()
+	if stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("Peek must not change statistics, got %d hits, %d misses", stats.Hits, stats.Misses)
+	}
+	c.SetWithTTL("expired", CacheEntry{StatusCode: http.StatusOK}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.Peek("expired"); ok {
+		t.Error("expected expired entry not to be returned")
+	}
+}
+
+func TestMemoryCache_GetAcceptable(t *testing.T) {
+	c := NewMemoryCache(time.Hour, 0)
+	defer c.Shutdown()
+
+	c.SetWithTTL("stale", CacheEntry{StatusCode: http.StatusOK}, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := c.GetAcceptable("stale", time.Hour, nil); !ok {
+		t.Error("expected stale entry within max-stale to be returned")
+	}
+	if _, ok := c.GetAcceptable("stale", -1, nil); !ok {
+		t.Error("expected stale entry to be returned for unbounded max-stale")
+	}
+	if _, ok := c.GetAcceptable("stale", time.Millisecond, nil); ok {
+		t.Error("expected entry stale beyond max-stale to be rejected")
+	}
+	if _, ok := c.GetAcceptable("stale", -1, nil); ok {
+		t.Error("expected entry rejected for staleness to be removed")
+	}
+
+	c.Set("fresh", CacheEntry{StatusCode: http.StatusOK})
+	if _, ok := c.GetAcceptable("fresh", 0, func(CacheEntry) bool { return false }); ok {
+		t.Error("expected entry rejected by accept to be a miss")
+	}
+	if _, ok := c.GetAcceptable("fresh", 0, func(CacheEntry) bool { return true }); !ok {
+		t.Error("expected accepted entry to be returned")
+	}
+	if stats := c.GetStats(); stats.Hits != 3 || stats.Misses != 3 {
+		t.Errorf("got %d hits, %d misses; want 3, 3", stats.Hits, stats.Misses)
+	}
+}
+
+func TestMemoryCache_AddEncoding(t *testing.T) {
+	c := NewMemoryCache(time.Hour, 1)
+	defer c.Shutdown()
+
+	c.Set("key", CacheEntry{StatusCode: http.StatusOK, Body: []byte("hello")})
+	entry, _ := c.Peek("key")
+
+	c.AddEncoding("key", "gzip", []byte("abc"), entry.StoredAt)
+	got, _ := c.Peek("key")
+	if string(got.Encodings["gzip"]) != "abc" {
+		t.Fatalf("expected gzip encoding to be held, got %v", got.Encodings)
+	}
+	if size := c.GetStats().TotalSize; size != 8 {
+		t.Errorf("got total size %d, want 8", size)
+	}
+	if entry.Encodings != nil {
+		t.Error("expected entries handed out earlier to be unchanged")
+	}
+
+	c.AddEncoding("key", "deflate", []byte("x"), time.Now().Add(time.Hour))
+	if got, _ := c.Peek("key"); got.Encodings["deflate"] != nil {
+		t.Error("expected encoding for a replaced entry to be ignored")
+	}
+
+	c.AddEncoding("key", "deflate", make([]byte, 1024*1024), entry.StoredAt)
+	if got, _ := c.Peek("key"); got.Encodings["deflate"] != nil {
+		t.Error("expected encoding that does not fit to be ignored")
+	}
+
+	c.PurgeAll()
+	if size := c.GetStats().TotalSize; size != 0 {
+		t.Errorf("got total size %d after purge, want 0", size)
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultNamespace is the name under which a Registry holds its default cache.
+const DefaultNamespace = "default"
+
+// Registry holds one MemoryCache per namespace. The default namespace always exists.
+type Registry struct {
+	mu     sync.RWMutex
+	caches map[string]*MemoryCache
+}
+
+// NewRegistry creates a Registry whose default namespace is served by c.
+func NewRegistry(c *MemoryCache) *Registry {
+	return &Registry{
+		caches: map[string]*MemoryCache{DefaultNamespace: c},
+	}
+}
+
+// Default returns the cache for the default namespace.
+func (r *Registry) Default() *MemoryCache {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caches[DefaultNamespace]
+}
+
+// Get returns the cache for a namespace. An empty name selects the default namespace.
+func (r *Registry) Get(name string) (*MemoryCache, bool) {
+	if name == "" {
+		name = DefaultNamespace
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.caches[name]
+	return c, ok
+}
+
+// Add registers c under name, replacing any existing cache for that namespace.
+func (r *Registry) Add(name string, c *MemoryCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.caches[name] = c
+}
+
+// Names returns the registered namespaces in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.caches))
+	for name := range r.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shutdown stops the background cleanup of every registered cache.
+func (r *Registry) Shutdown() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.caches {
+		c.Shutdown()
+	}
+}
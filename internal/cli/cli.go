@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"syscall"
 
 	"github.com/gbmerrall/gocache/internal/pidfile"
@@ -16,6 +18,7 @@ import (
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+	namespace  string // Cache namespace for scoped commands; empty means the default
 }
 
 // NewClient creates a new Client for the Control API.
@@ -26,18 +29,61 @@ func NewClient(port int) *Client {
 	}
 }
 
+// endpoint returns the URL for a control API path scoped to the client's namespace.
+func (c *Client) endpoint(path string) string {
+	if c.namespace == "" {
+		return c.baseURL + path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return c.baseURL + path + sep + "namespace=" + url.QueryEscape(c.namespace)
+}
+
+// extractNamespace removes a --namespace flag from anywhere in args and returns its value.
+func extractNamespace(args []string) (string, []string, error) {
+	var namespace string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--namespace" || arg == "-namespace":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("--namespace requires a value")
+			}
+			namespace = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--namespace="):
+			namespace = strings.TrimPrefix(arg, "--namespace=")
+		case strings.HasPrefix(arg, "-namespace="):
+			namespace = strings.TrimPrefix(arg, "-namespace=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return namespace, rest, nil
+}
+
 // Run executes a command based on the provided arguments.
 func Run(port int, args []string) error {
+	namespace, args, err := extractNamespace(args)
+	if err != nil {
+		return err
+	}
 	if len(args) < 1 {
 		return fmt.Errorf("no command provided")
 	}
 
 	client := NewClient(port)
+	client.namespace = namespace
 	command := args[0]
 
 	switch command {
 	case "status":
 		return client.GetStatus()
+	case "namespaces":
+		return client.ListNamespaces()
 	case "purge":
 		if len(args) < 2 {
 			return fmt.Errorf("domain required for purge command")
@@ -49,7 +95,11 @@ func Run(port int, args []string) error {
 		}
 		return client.PurgeURL(args[1])
 	case "purge-all":
-		fmt.Print("Are you sure you want to clear the entire cache? [y/N] ")
+		if client.namespace != "" {
+			fmt.Printf("Are you sure you want to clear the entire %q namespace? [y/N] ", client.namespace)
+		} else {
+			fmt.Print("Are you sure you want to clear the entire cache? [y/N] ")
+		}
 		var response string
 		fmt.Scanln(&response)
 		if response == "y" || response == "Y" {
@@ -63,6 +113,14 @@ func Run(port int, args []string) error {
 			filename = args[1]
 		}
 		return client.ExportCA(filename)
+	case "put":
+		return runPut(client, args[1:])
+	case "load":
+		return runLoad(client, args[1:])
+	case "warm":
+		return runWarm(client, args[1:])
+	case "key":
+		return runKey(client, args[1:])
 	case "stop":
 		return stopDaemon()
 	default:
@@ -92,7 +150,7 @@ func stopDaemon() error {
 
 // GetStatus fetches and displays the cache statistics.
 func (c *Client) GetStatus() error {
-	resp, err := c.httpClient.Get(c.baseURL + "/stats")
+	resp, err := c.httpClient.Get(c.endpoint("/stats"))
 	if err != nil {
 		return fmt.Errorf("could not connect to gocache server: %w", err)
 	}
@@ -109,6 +167,9 @@ func (c *Client) GetStatus() error {
 	}
 
 	fmt.Println("GoCache Status:")
+	if ns, ok := stats["namespace"].(string); ok {
+		fmt.Printf("  Namespace: %s\n", ns)
+	}
 	fmt.Printf("  Uptime: %s seconds\n", stats["uptime_seconds"])
 	fmt.Printf("  Cache Entries: %.0f\n", stats["entry_count"])
 	fmt.Printf("  Cache Size: %.2f bytes\n", stats["cache_size_bytes"])
@@ -120,9 +181,42 @@ func (c *Client) GetStatus() error {
 	return nil
 }
 
+// ListNamespaces fetches and displays every cache namespace with its size.
+func (c *Client) ListNamespaces() error {
+	resp, err := c.httpClient.Get(c.baseURL + "/namespaces")
+	if err != nil {
+		return fmt.Errorf("could not connect to gocache server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned non-200 status: %s\n%s", resp.Status, string(body))
+	}
+
+	var result struct {
+		Namespaces []struct {
+			Name           string `json:"name"`
+			EntryCount     int    `json:"entry_count"`
+			CacheSizeBytes int64  `json:"cache_size_bytes"`
+			HitCount       uint64 `json:"hit_count"`
+			MissCount      uint64 `json:"miss_count"`
+		} `json:"namespaces"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("could not decode server response: %w", err)
+	}
+
+	fmt.Println("GoCache Namespaces:")
+	for _, ns := range result.Namespaces {
+		fmt.Printf("  %s: %d entries, %d bytes, %d hits, %d misses\n", ns.Name, ns.EntryCount, ns.CacheSizeBytes, ns.HitCount, ns.MissCount)
+	}
+	return nil
+}
+
 // PurgeAll sends a request to purge the entire cache.
 func (c *Client) PurgeAll() error {
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/purge/all", nil)
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/purge/all"), nil)
 	if err != nil {
 		return err
 	}
@@ -141,7 +235,7 @@ func (c *Client) PurgeAll() error {
 // PurgeURL sends a request to purge a specific URL.
 func (c *Client) PurgeURL(url string) error {
 	body, _ := json.Marshal(map[string]string{"url": url})
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/purge/url", bytes.NewBuffer(body))
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/purge/url"), bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
@@ -164,7 +258,7 @@ func (c *Client) PurgeURL(url string) error {
 
 // PurgeDomain sends a request to purge a domain.
 func (c *Client) PurgeDomain(domain string) error {
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/purge/domain/"+domain, nil)
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/purge/domain/"+domain), nil)
 	if err != nil {
 		return err
 	}
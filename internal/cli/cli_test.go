@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -305,3 +306,54 @@ func TestExportCAFileWriteError(t *testing.T) {
 		t.Error("expected error for invalid file path")
 	}
 }
+
+func TestExtractNamespace(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantNS   string
+		wantRest []string
+	}{
+		{[]string{"status"}, "", []string{"status"}},
+		{[]string{"--namespace", "proj", "status"}, "proj", []string{"status"}},
+		{[]string{"purge", "example.com", "--namespace=proj"}, "proj", []string{"purge", "example.com"}},
+		{[]string{"warm", "-namespace", "proj", "urls.txt"}, "proj", []string{"warm", "urls.txt"}},
+	}
+	for _, tt := range tests {
+		ns, rest, err := extractNamespace(tt.args)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+		if ns != tt.wantNS || strings.Join(rest, " ") != strings.Join(tt.wantRest, " ") {
+			t.Errorf("%v: got %q %v, want %q %v", tt.args, ns, rest, tt.wantNS, tt.wantRest)
+		}
+	}
+
+	if _, _, err := extractNamespace([]string{"status", "--namespace"}); err == nil {
+		t.Error("expected error for missing namespace value")
+	}
+}
+
+func TestNamespaceScopedRequests(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"purged_count": 1})
+	}))
+	defer server.Close()
+
+	client := &Client{
+		baseURL:    server.URL,
+		httpClient: &http.Client{},
+		namespace:  "proj",
+	}
+	if err := client.PurgeDomain("example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotQuery != "namespace=proj" {
+		t.Errorf("got query %q, want namespace=proj", gotQuery)
+	}
+	if got := client.endpoint("/warm/status?id=1"); got != server.URL+"/warm/status?id=1&namespace=proj" {
+		t.Errorf("got endpoint %q", got)
+	}
+}
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// runPut handles `gocache put <url> [--file f] [--body s] [--status n] [--ttl d] [-H h]...`.
+func runPut(client *Client, args []string) error {
+	fs := newFlagSet("put")
+	file := fs.String("file", "", "file containing the response body")
+	body := fs.String("body", "", "response body")
+	status := fs.Int("status", http.StatusOK, "response status code")
+	ttl := fs.String("ttl", "", "entry TTL (0 = never expires, empty = default_ttl)")
+	var headers headerFlags
+	fs.Var(&headers, "H", "response header, may be repeated")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("url required for put command")
+	}
+	if *file != "" && *body != "" {
+		return fmt.Errorf("--file and --body are mutually exclusive")
+	}
+
+	entry := map[string]interface{}{
+		"url":    positional[0],
+		"status": *status,
+		"ttl":    *ttl,
+	}
+	if *body != "" {
+		entry["body"] = *body
+	}
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("could not read body file: %w", err)
+		}
+		entry["body_base64"] = base64.StdEncoding.EncodeToString(data)
+	}
+	hdr := headers.header()
+	if hdr.Get("Content-Type") == "" && *file != "" {
+		if ct := mime.TypeByExtension(filepath.Ext(*file)); ct != "" {
+			hdr.Set("Content-Type", ct)
+		}
+	}
+	// Send every value, so repeated headers such as Set-Cookie survive
+	entry["headers"] = hdr
+
+	return client.PutEntry(entry)
+}
+
+// runLoad handles `gocache load <dir|manifest.json> [--base-url u] [--ttl d]`.
+func runLoad(client *Client, args []string) error {
+	fs := newFlagSet("load")
+	baseURL := fs.String("base-url", "", "URL prefix for files in a fixture directory")
+	ttl := fs.String("ttl", "", "TTL for loaded entries (0 = never expires)")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("directory or manifest required for load command")
+	}
+
+	// The server resolves paths itself, so send it an absolute one.
+	path, err := filepath.Abs(positional[0])
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	req := map[string]string{"ttl": *ttl}
+	if info.IsDir() {
+		if *baseURL == "" {
+			return fmt.Errorf("--base-url is required when loading a directory")
+		}
+		req["dir"] = path
+		req["base_url"] = *baseURL
+	} else {
+		req["manifest"] = path
+	}
+	return client.LoadEntries(req)
+}
+
+// PutEntry injects a single response into the cache.
+func (c *Client) PutEntry(entry map[string]interface{}) error {
+	body, _ := json.Marshal(entry)
+	req, err := http.NewRequest(http.MethodPut, c.endpoint("/entry"), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not connect to gocache server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned non-200 status: %s\n%s", resp.Status, string(msg))
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("could not decode server response: %w", err)
+	}
+	fmt.Printf("Stored %s (key %s)\n", entry["url"], result["key"])
+	return nil
+}
+
+// LoadEntries injects a fixture directory or manifest into the cache.
+func (c *Client) LoadEntries(request map[string]string) error {
+	body, _ := json.Marshal(request)
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/entry/bulk"), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not connect to gocache server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned non-200 status: %s\n%s", resp.Status, string(msg))
+	}
+
+	var result struct {
+		LoadedCount int `json:"loaded_count"`
+		Failed      []struct {
+			URL   string `json:"url"`
+			Error string `json:"error"`
+		} `json:"failed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("could not decode server response: %w", err)
+	}
+	fmt.Printf("Loaded %d entries.\n", result.LoadedCount)
+	for _, f := range result.Failed {
+		fmt.Printf("  failed: %s: %s\n", f.URL, f.Error)
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d entries failed to load", len(result.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunPut(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/entry" || r.Method != http.MethodPut {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]string{"key": "http://example.com/"})
+	}))
+	defer server.Close()
+	client := &Client{baseURL: server.URL, httpClient: &http.Client{}}
+
+	t.Run("file body with flags after url", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "page.html")
+		os.WriteFile(file, []byte("<p>hi</p>"), 0644)
+
+		err := runPut(client, []string{"http://example.com/", "--file", file, "--status", "203", "--ttl", "0", "-H", "X-Test: 1",
+			"-H", "Set-Cookie: a=1", "-H", "Set-Cookie: b=2"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got["url"] != "http://example.com/" {
+			t.Errorf("got url %v", got["url"])
+		}
+		if got["status"].(float64) != 203 {
+			t.Errorf("got status %v, want 203", got["status"])
+		}
+		if got["ttl"] != "0" {
+			t.Errorf("got ttl %v, want 0", got["ttl"])
+		}
+		body, _ := base64.StdEncoding.DecodeString(got["body_base64"].(string))
+		if string(body) != "<p>hi</p>" {
+			t.Errorf("got body %q", body)
+		}
+		var sent struct {
+			Headers http.Header `json:"headers"`
+		}
+		data, _ := json.Marshal(got)
+		json.Unmarshal(data, &sent)
+		if sent.Headers.Get("X-Test") != "1" {
+			t.Errorf("expected X-Test header, got %v", sent.Headers)
+		}
+		if cookies := sent.Headers.Values("Set-Cookie"); len(cookies) != 2 || cookies[0] != "a=1" || cookies[1] != "b=2" {
+			t.Errorf("expected both Set-Cookie values, got %q", cookies)
+		}
+		if sent.Headers.Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("expected content type from extension, got %v", sent.Headers.Get("Content-Type"))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if err := runPut(client, []string{}); err == nil || err.Error() != "url required for put command" {
+			t.Errorf("expected url required error, got %v", err)
+		}
+		if err := runPut(client, []string{"http://x/", "--body", "a", "--file", "b"}); err == nil {
+			t.Error("expected error for --body with --file")
+		}
+		if err := runPut(client, []string{"http://x/", "-H", "nocolon"}); err == nil {
+			t.Error("expected error for malformed header")
+		}
+		if err := runPut(client, []string{"http://x/", "--file", "/nonexistent"}); err == nil {
+			t.Error("expected error for missing file")
+		}
+	})
+}
+
+func TestRunLoad(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/entry/bulk" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]interface{}{"loaded_count": 3, "failed": []interface{}{}})
+	}))
+	defer server.Close()
+	client := &Client{baseURL: server.URL, httpClient: &http.Client{}}
+
+	dir := t.TempDir()
+	if err := runLoad(client, []string{dir, "--base-url", "http://fixtures.test"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["dir"] != dir || got["base_url"] != "http://fixtures.test" {
+		t.Errorf("unexpected request: %v", got)
+	}
+
+	manifest := filepath.Join(dir, "manifest.json")
+	os.WriteFile(manifest, []byte(`{"entries":[]}`), 0644)
+	if err := runLoad(client, []string{manifest}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["manifest"] != manifest {
+		t.Errorf("expected manifest path, got %v", got)
+	}
+
+	if err := runLoad(client, []string{dir}); err == nil {
+		t.Error("expected error loading directory without --base-url")
+	}
+	if err := runLoad(client, []string{}); err == nil {
+		t.Error("expected error with no path")
+	}
+}
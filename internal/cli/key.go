@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// runKey handles `gocache key <url> [-H h]...`.
+func runKey(client *Client, args []string) error {
+	fs := newFlagSet("key")
+	var headers headerFlags
+	fs.Var(&headers, "H", "request header, may be repeated")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("url required for key command")
+	}
+
+	hdr := headers.header()
+	flat := make(map[string]string, len(hdr))
+	for k := range hdr {
+		flat[k] = hdr.Get(k)
+	}
+	return client.ShowKey(positional[0], flat)
+}
+
+// ShowKey prints the cache key the server computes for a GET of rawURL and
+// whether that key is currently cached.
+func (c *Client) ShowKey(rawURL string, headers map[string]string) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"url":     rawURL,
+		"headers": headers,
+	})
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/key"), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not connect to gocache server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned non-200 status: %s\n%s", resp.Status, string(msg))
+	}
+
+	var result struct {
+		Key    string `json:"key"`
+		Cached bool   `json:"cached"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("could not decode server response: %w", err)
+	}
+	fmt.Println(result.Key)
+	if result.Cached {
+		fmt.Println("  (cached)")
+	} else {
+		fmt.Println("  (not cached)")
+	}
+	return nil
+}
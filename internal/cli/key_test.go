@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunKey(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/key" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]interface{}{"key": "http://example.com/", "cached": true})
+	}))
+	defer server.Close()
+	client := &Client{baseURL: server.URL, httpClient: &http.Client{}}
+
+	if err := runKey(client, []string{"http://Example.com:80/", "-H", "Accept-Language: en"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["url"] != "http://Example.com:80/" {
+		t.Errorf("got url %v", got["url"])
+	}
+	headers := got["headers"].(map[string]interface{})
+	if headers["Accept-Language"] != "en" {
+		t.Errorf("expected Accept-Language header, got %v", headers)
+	}
+
+	if err := runKey(client, nil); err == nil || err.Error() != "url required for key command" {
+		t.Errorf("expected url required error, got %v", err)
+	}
+}
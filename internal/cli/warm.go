@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/warm"
+)
+
+// stdin is the source for `gocache warm -`; replaced in tests.
+var stdin io.Reader = os.Stdin
+
+// warmPollInterval is how often `gocache warm` checks job progress.
+var warmPollInterval = 500 * time.Millisecond
+
+// floatFlag records whether a float flag was explicitly set.
+type floatFlag struct {
+	value float64
+	set   bool
+}
+
+func (f *floatFlag) String() string {
+	return fmt.Sprint(f.value)
+}
+
+func (f *floatFlag) Set(v string) error {
+	_, err := fmt.Sscan(v, &f.value)
+	f.set = err == nil
+	return err
+}
+
+// stringsFlag collects a repeated string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// runWarm handles `gocache warm <file|-|url>... [--sitemap url]... [--concurrency n] [--rate r] [--no-wait]`.
+func runWarm(client *Client, args []string) error {
+	fs := newFlagSet("warm")
+	concurrency := fs.Int("concurrency", 0, "concurrent fetches (default from config)")
+	var rate floatFlag
+	fs.Var(&rate, "rate", "requests per second per host (default from config)")
+	var sitemaps stringsFlag
+	fs.Var(&sitemaps, "sitemap", "sitemap URL, may be repeated")
+	noWait := fs.Bool("no-wait", false, "start the job and return without waiting")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	req := map[string]interface{}{}
+	var urls []string
+	for _, arg := range positional {
+		u, s, err := readWarmSource(arg)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, u...)
+		sitemaps = append(sitemaps, s...)
+	}
+	if len(urls) == 0 && len(sitemaps) == 0 {
+		return fmt.Errorf("urls or sitemaps required for warm command")
+	}
+	req["urls"] = urls
+	req["sitemaps"] = []string(sitemaps)
+	if *concurrency > 0 {
+		req["concurrency"] = *concurrency
+	}
+	if rate.set {
+		req["rate_per_host"] = rate.value
+	}
+
+	id, err := client.StartWarm(req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Warm job %s started.\n", id)
+	if *noWait {
+		return nil
+	}
+	return client.WaitWarm(id)
+}
+
+// readWarmSource reads URLs from a file, stdin ("-") or a single URL argument.
+// Arguments that name a sitemap, by URL or by content, are returned as sitemaps.
+func readWarmSource(arg string) (urls []string, sitemaps []string, err error) {
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		if strings.HasSuffix(arg, ".xml") || strings.HasSuffix(arg, ".xml.gz") {
+			return nil, []string{arg}, nil
+		}
+		return []string{arg}, nil, nil
+	}
+
+	var data []byte
+	if arg == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read url list: %w", err)
+	}
+
+	if warm.IsSitemap(data) {
+		return warm.ParseSitemap(data)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, nil, scanner.Err()
+}
+
+// StartWarm submits a warm job and returns its ID.
+func (c *Client) StartWarm(request map[string]interface{}) (string, error) {
+	body, _ := json.Marshal(request)
+	resp, err := c.httpClient.Post(c.endpoint("/warm"), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", fmt.Errorf("could not connect to gocache server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("server returned unexpected status: %s\n%s", resp.Status, string(msg))
+	}
+
+	var result map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("could not decode server response: %w", err)
+	}
+	return result["job_id"], nil
+}
+
+// WarmStatus fetches the status of a warm job.
+func (c *Client) WarmStatus(id string) (warm.Status, error) {
+	var status warm.Status
+	resp, err := c.httpClient.Get(c.baseURL + "/warm/status?id=" + id)
+	if err != nil {
+		return status, fmt.Errorf("could not connect to gocache server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return status, fmt.Errorf("server returned non-200 status: %s\n%s", resp.Status, string(msg))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("could not decode server response: %w", err)
+	}
+	return status, nil
+}
+
+// WaitWarm reports progress of a warm job until it finishes, then prints a summary.
+func (c *Client) WaitWarm(id string) error {
+	for {
+		status, err := c.WarmStatus(id)
+		if err != nil {
+			return err
+		}
+		if status.State != warm.StateRunning {
+			fmt.Printf("\rWarming: %d/%d done, %d failed\n", status.Done, status.Total, status.Failed)
+			printWarmSummary(status)
+			if status.Failed > 0 {
+				return fmt.Errorf("%d urls failed to warm", status.Failed)
+			}
+			return nil
+		}
+		fmt.Printf("\rWarming: %d/%d done, %d failed", status.Done, status.Total, status.Failed)
+		time.Sleep(warmPollInterval)
+	}
+}
+
+func printWarmSummary(status warm.Status) {
+	fmt.Printf("Warm job %s %s in %s:\n", status.ID, status.State, status.Elapsed)
+	fmt.Printf("  Succeeded: %d (%d already cached)\n", status.Succeeded, status.Hits)
+	fmt.Printf("  Failed: %d\n", status.Failed)
+	for _, f := range status.Failures {
+		if f.StatusCode != 0 {
+			fmt.Printf("    %s: %d %s\n", f.URL, f.StatusCode, f.Error)
+		} else {
+			fmt.Printf("    %s: %s\n", f.URL, f.Error)
+		}
+	}
+}
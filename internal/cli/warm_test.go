@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/warm"
+)
+
+func TestReadWarmSource(t *testing.T) {
+	dir := t.TempDir()
+
+	list := filepath.Join(dir, "urls.txt")
+	os.WriteFile(list, []byte("# comment\nhttp://a.test/1\n\n  http://a.test/2  \n"), 0644)
+	urls, sitemaps, err := readWarmSource(list)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 2 || urls[1] != "http://a.test/2" || len(sitemaps) != 0 {
+		t.Errorf("got urls %v sitemaps %v", urls, sitemaps)
+	}
+
+	sitemap := filepath.Join(dir, "sitemap.xml")
+	os.WriteFile(sitemap, []byte(`<sitemapindex><sitemap><loc>http://a.test/s.xml</loc></sitemap></sitemapindex>`), 0644)
+	urls, sitemaps, err = readWarmSource(sitemap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 0 || len(sitemaps) != 1 {
+		t.Errorf("got urls %v sitemaps %v", urls, sitemaps)
+	}
+
+	_, sitemaps, _ = readWarmSource("https://a.test/sitemap.xml")
+	if len(sitemaps) != 1 {
+		t.Errorf("expected sitemap URL argument to be treated as sitemap, got %v", sitemaps)
+	}
+	urls, _, _ = readWarmSource("https://a.test/page")
+	if len(urls) != 1 {
+		t.Errorf("expected URL argument to be warmed directly, got %v", urls)
+	}
+
+	stdin = strings.NewReader("http://stdin.test/\n")
+	defer func() { stdin = os.Stdin }()
+	urls, _, err = readWarmSource("-")
+	if err != nil || len(urls) != 1 || urls[0] != "http://stdin.test/" {
+		t.Errorf("got urls %v, err %v", urls, err)
+	}
+
+	if _, _, err := readWarmSource(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestRunWarm(t *testing.T) {
+	warmPollInterval = time.Millisecond
+	defer func() { warmPollInterval = 500 * time.Millisecond }()
+
+	var got map[string]interface{}
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/warm" && r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"job_id": "7"})
+		case r.URL.Path == "/warm/status" && r.URL.Query().Get("id") == "7":
+			polls++
+			s := warm.Status{ID: "7", State: warm.StateRunning, Total: 2, Done: 1}
+			if polls > 1 {
+				s.State = warm.StateCompleted
+				s.Done, s.Succeeded = 2, 2
+			}
+			json.NewEncoder(w).Encode(s)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := &Client{baseURL: server.URL, httpClient: &http.Client{}}
+
+	err := runWarm(client, []string{"http://a.test/", "--concurrency", "8", "--rate", "2.5", "--sitemap", "http://a.test/s.xml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if polls < 2 {
+		t.Errorf("expected progress polling, got %d polls", polls)
+	}
+	if got["concurrency"].(float64) != 8 || got["rate_per_host"].(float64) != 2.5 {
+		t.Errorf("unexpected options: %v", got)
+	}
+	if len(got["sitemaps"].([]interface{})) != 1 || len(got["urls"].([]interface{})) != 1 {
+		t.Errorf("unexpected sources: %v", got)
+	}
+
+	if err := runWarm(client, []string{}); err == nil {
+		t.Error("expected error with nothing to warm")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/gbmerrall/gocache/internal/cache"
 	"github.com/gbmerrall/gocache/internal/config"
 	"github.com/gbmerrall/gocache/internal/proxy"
+	"github.com/gbmerrall/gocache/internal/warm"
 )
 
 // ControlAPI provides an HTTP interface for managing the cache and proxy.
@@ -22,6 +23,7 @@ type ControlAPI struct {
 	config    *config.Config
 	cache     *cache.MemoryCache
 	proxy     *proxy.Proxy
+	warmer    *warm.Manager
 	startTime time.Time
 	server    *http.Server
 	shutdown  func() // Function to trigger graceful shutdown
@@ -34,6 +36,7 @@ func NewControlAPI(logger *slog.Logger, cfg *config.Config, c *cache.MemoryCache
 		config:    cfg,
 		cache:     c,
 		proxy:     p,
+		warmer:    warm.NewManager(logger, p),
 		startTime: time.Now(),
 		shutdown:  shutdown,
 	}
@@ -58,6 +61,12 @@ func (a *ControlAPI) Start() error {
 	mux.HandleFunc("/health", a.handleHealth)
 	mux.HandleFunc("/shutdown", a.handleShutdown)
 	mux.HandleFunc("/reload", a.handleReload)
+	mux.HandleFunc("/entry", a.handleEntry)
+	mux.HandleFunc("/entry/bulk", a.handleEntryBulk)
+	mux.HandleFunc("/warm", a.handleWarm)
+	mux.HandleFunc("/warm/status", a.handleWarmStatus)
+	mux.HandleFunc("/namespaces", a.handleNamespaces)
+	mux.HandleFunc("/key", a.handleKey)
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -78,6 +87,7 @@ func (a *ControlAPI) Start() error {
 // Shutdown gracefully shuts down the control API server.
 func (a *ControlAPI) Shutdown(ctx context.Context) error {
 	a.logger.Info("shutting down control API")
+	a.warmer.Shutdown()
 	return a.server.Shutdown(ctx)
 }
 
@@ -90,6 +100,23 @@ func (a *ControlAPI) ReloadConfig() error {
 
 	a.config = newCfg
 	a.cache.UpdateTTL(newCfg.Cache.GetDefaultTTL())
+	registry := a.proxy.Namespaces()
+	for _, name := range registry.Names() {
+		if name == cache.DefaultNamespace {
+			continue
+		}
+		c, _ := registry.Get(name)
+		if ns, ok := newCfg.Namespaces[name]; ok {
+			c.UpdateTTL(ns.GetDefaultTTL(&newCfg.Cache))
+		} else {
+			a.logger.Warn("namespace removed from config; it stays active until restart", "namespace", name)
+		}
+	}
+	for name := range newCfg.Namespaces {
+		if _, ok := registry.Get(name); !ok {
+			a.logger.Warn("new namespace in config; restart to enable it", "namespace", name)
+		}
+	}
 	a.proxy.SetConfig(newCfg)
 
 	a.logger.Info("configuration reloaded successfully")
@@ -130,7 +157,11 @@ func (a *ControlAPI) handleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	a.logger.Debug("stats endpoint accessed", "remoteAddr", r.RemoteAddr)
-	stats := a.cache.GetStats()
+	namespace, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+	stats := c.GetStats()
 	totalRequests := stats.Hits + stats.Misses
 	var hitRate float64
 	if totalRequests > 0 {
@@ -140,18 +171,37 @@ func (a *ControlAPI) handleStats(w http.ResponseWriter, r *http.Request) {
 	// Get cert cache stats
 	certCacheSize, certEvictions := a.proxy.GetCertCacheMetrics()
 	certMaxEntries := a.config.Server.MaxCertCacheEntries
+	refreshes, refreshFailures := a.proxy.GetRefreshMetrics()
+	mitm := a.proxy.GetMITMConnectionMetrics()
+	var requestsPerConnection float64
+	if mitm.Connections > 0 {
+		requestsPerConnection = float64(mitm.Requests) / float64(mitm.Connections)
+	}
 
 	response := map[string]interface{}{
-		"hit_count":              stats.Hits,
-		"miss_count":             stats.Misses,
-		"hit_rate_percent":       fmt.Sprintf("%.2f", hitRate),
-		"entry_count":            stats.EntryCount,
-		"uptime_seconds":         fmt.Sprintf("%.2f", stats.UptimeSeconds),
-		"cache_size_bytes":       stats.TotalSize,
-		"cert_cache_count":       a.proxy.GetCertCacheStats(),
-		"cert_cache_size":        certCacheSize,
-		"cert_cache_evictions":   certEvictions,
-		"cert_cache_max_entries": certMaxEntries,
+		"namespace":                        namespace,
+		"hit_count":                        stats.Hits,
+		"miss_count":                       stats.Misses,
+		"hit_rate_percent":                 fmt.Sprintf("%.2f", hitRate),
+		"entry_count":                      stats.EntryCount,
+		"uptime_seconds":                   fmt.Sprintf("%.2f", stats.UptimeSeconds),
+		"cache_size_bytes":                 stats.TotalSize,
+		"cert_cache_count":                 a.proxy.GetCertCacheStats(),
+		"cert_cache_size":                  certCacheSize,
+		"cert_cache_evictions":             certEvictions,
+		"cert_cache_max_entries":           certMaxEntries,
+		"refresh_count":                    refreshes,
+		"refresh_failures":                 refreshFailures,
+		"invalidation_count":               a.proxy.GetInvalidationCount(),
+		"mitm_connections":                 mitm.Connections,
+		"mitm_open_connections":            mitm.Open,
+		"mitm_requests":                    mitm.Requests,
+		"mitm_requests_per_connection":     fmt.Sprintf("%.2f", requestsPerConnection),
+		"mitm_max_requests_per_connection": mitm.MaxPerConnection,
+		"tunnel_count":                     a.proxy.GetTunnelCount(),
+		"mitm_cert_rejections":             a.proxy.GetCertRejectionCount(),
+		"rejected_hosts":                   a.proxy.GetRejectedHosts(),
+		"upgrade_count":                    a.proxy.GetUpgradeCount(),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -165,8 +215,12 @@ func (a *ControlAPI) handlePurgeAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	a.logger.Debug("purge all endpoint accessed", "remoteAddr", r.RemoteAddr)
-	count := a.cache.PurgeAll()
-	a.logger.Info("purged all cache entries", "count", count)
+	namespace, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+	count := c.PurgeAll()
+	a.logger.Info("purged all cache entries", "namespace", namespace, "count", count)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
 		"purged_count": count,
@@ -193,13 +247,25 @@ func (a *ControlAPI) handlePurgeURL(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "URL is required", http.StatusBadRequest)
 		return
 	}
-	found := a.cache.PurgeByURL(req.URL)
-	a.logger.Info("purge request by URL", "url", req.URL, "found", found)
-	a.logger.Debug("purge by URL details", "url", req.URL, "found", found, "remoteAddr", r.RemoteAddr)
+	namespace, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+	key, err := a.proxy.CacheKeyForURL(req.URL)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Drop the folded headers and cookies, so every variant of the URL goes
+	key, _, _ = strings.Cut(key, "#")
+	count := c.PurgeVariants(key)
+	a.logger.Info("purge request by URL", "namespace", namespace, "url", req.URL, "purged", count)
+	a.logger.Debug("purge by URL details", "url", req.URL, "key", key, "purged", count, "remoteAddr", r.RemoteAddr)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"url":    req.URL,
-		"purged": found,
+		"url":          req.URL,
+		"purged":       count > 0,
+		"purged_count": count,
 	}); err != nil {
 		a.logger.Error("failed to encode purge url response", "error", err)
 	}
@@ -215,8 +281,12 @@ func (a *ControlAPI) handlePurgeDomain(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Domain is required", http.StatusBadRequest)
 		return
 	}
-	count := a.cache.PurgeByDomain(domain)
-	a.logger.Info("purged cache entries by domain", "domain", domain, "count", count)
+	namespace, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+	count := c.PurgeByDomain(domain)
+	a.logger.Info("purged cache entries by domain", "namespace", namespace, "domain", domain, "count", count)
 	a.logger.Debug("purge by domain details", "domain", domain, "count", count, "remoteAddr", r.RemoteAddr)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
@@ -425,6 +425,50 @@ func TestHandlePurgeURLMissingURL(t *testing.T) {
 	}
 }
 
+func TestHandlePurgeURLVariants(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+	api.config.Cache.Key.Headers = []string{"Accept-Language"}
+
+	entry := cache.CacheEntry{StatusCode: http.StatusOK, Body: []byte("page")}
+	for _, lang := range []string{"en", "fr"} {
+		key, err := api.proxy.CacheKeyForRequest("https://example.com/a", http.Header{"Accept-Language": {lang}})
+		if err != nil {
+			t.Fatalf("failed to build key: %v", err)
+		}
+		api.cache.Set(key, entry)
+	}
+	api.cache.Set("https://example.com/b", entry)
+
+	// The URL is normalized the way the proxy keys requests, and every
+	// variant stored under it is purged
+	body := `{"url": "https://Example.com:443/a"}`
+	w := httptest.NewRecorder()
+	api.handlePurgeURL(w, httptest.NewRequest(http.MethodPost, "/purge/url", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var result struct {
+		Purged      bool `json:"purged"`
+		PurgedCount int  `json:"purged_count"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Purged || result.PurgedCount != 2 {
+		t.Errorf("got %+v, want both variants purged", result)
+	}
+	if n := api.cache.GetStats().EntryCount; n != 1 {
+		t.Errorf("got %d entries, want only the other page left", n)
+	}
+
+	w = httptest.NewRecorder()
+	api.handlePurgeURL(w, httptest.NewRequest(http.MethodPost, "/purge/url", strings.NewReader(`{"url": "ftp://example.com/a"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unsupported scheme, got %d", w.Code)
+	}
+}
+
 func TestHandlePurgeDomainInvalidDomain(t *testing.T) {
 	api, cleanup := setupTestAPI(t)
 	defer cleanup()
@@ -532,4 +576,18 @@ func TestStatsIncludesCertCacheMetrics(t *testing.T) {
 	if _, ok := stats["cert_cache_max_entries"]; !ok {
 		t.Error("missing cert_cache_max_entries metric")
 	}
+	if _, ok := stats["refresh_count"]; !ok {
+		t.Error("missing refresh_count metric")
+	}
+	if _, ok := stats["refresh_failures"]; !ok {
+		t.Error("missing refresh_failures metric")
+	}
+	if _, ok := stats["invalidation_count"]; !ok {
+		t.Error("missing invalidation_count metric")
+	}
+	for _, name := range []string{"mitm_connections", "mitm_open_connections", "mitm_requests", "mitm_requests_per_connection", "mitm_max_requests_per_connection", "tunnel_count", "mitm_cert_rejections", "rejected_hosts", "upgrade_count"} {
+		if _, ok := stats[name]; !ok {
+			t.Errorf("missing %s metric", name)
+		}
+	}
 }
@@ -0,0 +1,328 @@
+package control
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// entryRequest describes a response to inject into the cache.
+// Exactly one of Body, BodyBase64 or File may be set; an empty body is allowed.
+type entryRequest struct {
+	URL        string       `json:"url"`
+	Status     int          `json:"status"`
+	Headers    entryHeaders `json:"headers"`
+	Body       string       `json:"body"`
+	BodyBase64 string       `json:"body_base64"`
+	File       string       `json:"file"`
+	TTL        string       `json:"ttl"` // Empty uses default_ttl, "0" never expires
+}
+
+// entryHeaders are the response headers of an injected entry. Each value is
+// a string, or an array of strings for repeated headers such as Set-Cookie.
+type entryHeaders http.Header
+
+func (h *entryHeaders) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	header := make(http.Header, len(raw))
+	for name, value := range raw {
+		var single string
+		if err := json.Unmarshal(value, &single); err == nil {
+			header.Add(name, single)
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			return fmt.Errorf("header %q must be a string or an array of strings", name)
+		}
+		for _, v := range values {
+			header.Add(name, v)
+		}
+	}
+	*h = entryHeaders(header)
+	return nil
+}
+
+// bulkEntryRequest loads many entries at once from a fixture directory,
+// a manifest file, or an inline list.
+type bulkEntryRequest struct {
+	Dir      string         `json:"dir"`
+	BaseURL  string         `json:"base_url"`
+	Manifest string         `json:"manifest"`
+	Entries  []entryRequest `json:"entries"`
+	TTL      string         `json:"ttl"` // Applied to entries that don't set their own
+}
+
+// entryManifest is the on-disk format of a fixture manifest.
+type entryManifest struct {
+	Entries []entryRequest `json:"entries"`
+}
+
+type entryFailure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// parseEntryTTL converts an injection TTL string to a cache TTL.
+// The boolean is false when the cache's default TTL should be used.
+func parseEntryTTL(s string) (time.Duration, bool, error) {
+	if s == "" {
+		return 0, false, nil
+	}
+	if s == "0" {
+		return cache.NoExpiry, true, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid ttl %q: %w", s, err)
+	}
+	if d < 0 {
+		return 0, false, fmt.Errorf("invalid ttl %q: must not be negative", s)
+	}
+	if d == 0 {
+		return cache.NoExpiry, true, nil
+	}
+	return d, true, nil
+}
+
+// storeEntry validates req and writes it to c, returning the cache key.
+// Relative file paths are resolved against baseDir.
+func (a *ControlAPI) storeEntry(c *cache.MemoryCache, req entryRequest, baseDir string) (string, error) {
+	if req.URL == "" {
+		return "", fmt.Errorf("url is required")
+	}
+	key, err := a.proxy.CacheKeyForURL(req.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+
+	status := req.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return "", fmt.Errorf("invalid status code: %d", status)
+	}
+
+	ttl, customTTL, err := parseEntryTTL(req.TTL)
+	if err != nil {
+		return "", err
+	}
+
+	sources := 0
+	for _, s := range []string{req.Body, req.BodyBase64, req.File} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", fmt.Errorf("only one of body, body_base64 or file may be set")
+	}
+
+	var body []byte
+	switch {
+	case req.BodyBase64 != "":
+		body, err = base64.StdEncoding.DecodeString(req.BodyBase64)
+		if err != nil {
+			return "", fmt.Errorf("invalid body_base64: %w", err)
+		}
+	case req.File != "":
+		file := req.File
+		if !filepath.IsAbs(file) && baseDir != "" {
+			file = filepath.Join(baseDir, file)
+		}
+		body, err = os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file: %w", err)
+		}
+	default:
+		body = []byte(req.Body)
+	}
+
+	headers := make(http.Header)
+	for k, values := range req.Headers {
+		for _, v := range values {
+			headers.Add(k, v)
+		}
+	}
+	// The proxy writes the length of the stored body; a stale value would corrupt responses.
+	headers.Del("Content-Length")
+	if headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", detectContentType(req.File, body))
+	}
+
+	entry := cache.CacheEntry{
+		StatusCode: status,
+		Headers:    headers,
+		Body:       body,
+	}
+	if customTTL {
+		c.SetWithTTL(key, entry, ttl)
+	} else {
+		c.Set(key, entry)
+	}
+	a.logger.Info("cache entry injected", "url", req.URL, "key", key, "status", status, "bodySize", len(body))
+	return key, nil
+}
+
+// detectContentType guesses a Content-Type from a file extension, falling back to sniffing.
+func detectContentType(filename string, body []byte) string {
+	if filename != "" {
+		if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+			return ct
+		}
+	}
+	return http.DetectContentType(body)
+}
+
+func (a *ControlAPI) handleEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req entryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	_, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+	key, err := a.storeEntry(c, req, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.logger.Debug("inject entry details", "url", req.URL, "ttl", req.TTL, "remoteAddr", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"url": req.URL,
+		"key": key,
+	}); err != nil {
+		a.logger.Error("failed to encode entry response", "error", err)
+	}
+}
+
+func (a *ControlAPI) handleEntryBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req bulkEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	namespace, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+
+	var entries []entryRequest
+	var baseDir string
+	var err error
+	switch {
+	case req.Dir != "":
+		if req.BaseURL == "" {
+			http.Error(w, "base_url is required when loading a directory", http.StatusBadRequest)
+			return
+		}
+		entries, err = entriesFromDir(req.Dir, req.BaseURL)
+		baseDir = req.Dir
+	case req.Manifest != "":
+		entries, err = entriesFromManifest(req.Manifest)
+		baseDir = filepath.Dir(req.Manifest)
+	case len(req.Entries) > 0:
+		entries = req.Entries
+	default:
+		http.Error(w, "one of dir, manifest or entries is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	loaded := 0
+	failed := []entryFailure{}
+	for _, e := range entries {
+		if e.TTL == "" {
+			e.TTL = req.TTL
+		}
+		if _, err := a.storeEntry(c, e, baseDir); err != nil {
+			failed = append(failed, entryFailure{URL: e.URL, Error: err.Error()})
+			continue
+		}
+		loaded++
+	}
+	a.logger.Info("bulk cache injection complete", "namespace", namespace, "loaded", loaded, "failed", len(failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"loaded_count": loaded,
+		"failed":       failed,
+	}); err != nil {
+		a.logger.Error("failed to encode bulk entry response", "error", err)
+	}
+}
+
+// entriesFromDir maps every regular file under dir to baseURL plus its relative path.
+// Hidden files and directories are skipped.
+func entriesFromDir(dir, baseURL string) ([]entryRequest, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var entries []entryRequest
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entryRequest{
+			URL:  baseURL + path.Clean("/"+filepath.ToSlash(rel)),
+			File: p,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture directory: %w", err)
+	}
+	return entries, nil
+}
+
+// entriesFromManifest reads a JSON manifest of entries.
+func entriesFromManifest(filename string) ([]entryRequest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m entryManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return m.Entries, nil
+}
@@ -0,0 +1,280 @@
+package control
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func putEntry(t *testing.T, api *ControlAPI, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPut, "/entry", bytes.NewReader(data))
+	rr := httptest.NewRecorder()
+	api.handleEntry(rr, req)
+	return rr
+}
+
+func TestHandleEntry(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+
+	t.Run("inline body with default TTL", func(t *testing.T) {
+		rr := putEntry(t, api, map[string]interface{}{
+			"url":     "http://example.com/page?b=2&a=1",
+			"status":  201,
+			"headers": map[string]string{"Content-Type": "text/html", "X-Fixture": "yes"},
+			"body":    "<h1>fixture</h1>",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		// The key must match what the proxy computes for the same URL.
+		entry, ok := api.cache.Get("http://example.com/page?a=1&b=2")
+		if !ok {
+			t.Fatal("expected injected entry to be in cache")
+		}
+		if entry.StatusCode != 201 {
+			t.Errorf("got status %d, want 201", entry.StatusCode)
+		}
+		if string(entry.Body) != "<h1>fixture</h1>" {
+			t.Errorf("got body %q", entry.Body)
+		}
+		if entry.Headers.Get("X-Fixture") != "yes" {
+			t.Errorf("expected X-Fixture header to be stored")
+		}
+		if entry.Expiry.IsZero() {
+			t.Error("expected default TTL to set an expiry")
+		}
+	})
+
+	t.Run("base64 body with no expiry", func(t *testing.T) {
+		rr := putEntry(t, api, map[string]interface{}{
+			"url":         "https://example.com/data.bin",
+			"body_base64": base64.StdEncoding.EncodeToString([]byte{0, 1, 2}),
+			"ttl":         "0",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		entry, ok := api.cache.Get("https://example.com/data.bin")
+		if !ok {
+			t.Fatal("expected injected entry to be in cache")
+		}
+		if !entry.Expiry.IsZero() {
+			t.Errorf("expected no expiry, got %v", entry.Expiry)
+		}
+		if entry.StatusCode != http.StatusOK {
+			t.Errorf("expected default status 200, got %d", entry.StatusCode)
+		}
+	})
+
+	t.Run("body from file with custom TTL", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "page.json")
+		os.WriteFile(file, []byte(`{"ok":true}`), 0644)
+
+		rr := putEntry(t, api, map[string]interface{}{
+			"url":  "http://example.com/api",
+			"file": file,
+			"ttl":  "5m",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		entry, ok := api.cache.Get("http://example.com/api")
+		if !ok {
+			t.Fatal("expected injected entry to be in cache")
+		}
+		if entry.Headers.Get("Content-Type") != "application/json" {
+			t.Errorf("expected content type from extension, got %q", entry.Headers.Get("Content-Type"))
+		}
+		if time.Until(entry.Expiry) > 5*time.Minute {
+			t.Errorf("expiry %v exceeds configured TTL", entry.Expiry)
+		}
+	})
+
+	t.Run("https entry served through the proxy", func(t *testing.T) {
+		rr := putEntry(t, api, map[string]interface{}{
+			"url":    "https://fixtures.test/login",
+			"status": 200,
+			"headers": map[string]interface{}{
+				"Content-Type": "text/html",
+				"Set-Cookie":   []string{"a=1", "b=2"},
+			},
+			"body": "<p>login</p>",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+
+		// The host does not resolve, so only the injected entry can answer
+		proxyServer := httptest.NewServer(api.proxy)
+		defer proxyServer.Close()
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+		resp, err := client.Get("https://fixtures.test/login")
+		if err != nil {
+			t.Fatalf("request through the proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.Header.Get("X-Cache") != "HIT" || string(body) != "<p>login</p>" {
+			t.Errorf("got X-Cache %q and body %q, want the injected entry", resp.Header.Get("X-Cache"), body)
+		}
+		if cookies := resp.Header.Values("Set-Cookie"); len(cookies) != 2 || cookies[0] != "a=1" || cookies[1] != "b=2" {
+			t.Errorf("got Set-Cookie %q, want both injected values", cookies)
+		}
+	})
+
+	t.Run("validation errors", func(t *testing.T) {
+		cases := []map[string]interface{}{
+			{"body": "no url"},
+			{"url": "ftp://example.com/file"},
+			{"url": "http://example.com", "status": 999},
+			{"url": "http://example.com", "ttl": "soon"},
+			{"url": "http://example.com", "ttl": "-1m"},
+			{"url": "http://example.com", "body": "a", "file": "/tmp/x"},
+			{"url": "http://example.com", "file": "/nonexistent/file"},
+			{"url": "http://example.com", "headers": map[string]interface{}{"X-Count": 1}},
+		}
+		for _, c := range cases {
+			if rr := putEntry(t, api, c); rr.Code != http.StatusBadRequest {
+				t.Errorf("%v: got status %d, want %d", c, rr.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/entry", nil)
+		rr := httptest.NewRecorder()
+		api.handleEntry(rr, req)
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestHandleEntryBulk(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+
+	post := func(body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/entry/bulk", bytes.NewReader(data))
+		rr := httptest.NewRecorder()
+		api.handleEntryBulk(rr, req)
+		var result map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr, result
+	}
+
+	t.Run("fixture directory", func(t *testing.T) {
+		dir := t.TempDir()
+		os.MkdirAll(filepath.Join(dir, "css"), 0755)
+		os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644)
+		os.WriteFile(filepath.Join(dir, "css", "site.css"), []byte("body{}"), 0644)
+		os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref"), 0644)
+
+		rr, result := post(map[string]string{"dir": dir, "base_url": "https://fixtures.test/", "ttl": "0"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if result["loaded_count"].(float64) != 2 {
+			t.Errorf("got loaded_count %v, want 2", result["loaded_count"])
+		}
+		entry, ok := api.cache.Get("https://fixtures.test/css/site.css")
+		if !ok {
+			t.Fatal("expected css fixture in cache")
+		}
+		if ct := entry.Headers.Get("Content-Type"); ct != "text/css; charset=utf-8" {
+			t.Errorf("got content type %q", ct)
+		}
+		if !entry.Expiry.IsZero() {
+			t.Error("expected bulk ttl to apply to entries")
+		}
+		if _, ok := api.cache.Get("https://fixtures.test/.git/HEAD"); ok {
+			t.Error("hidden files should be skipped")
+		}
+	})
+
+	t.Run("directory requires base url", func(t *testing.T) {
+		rr, _ := post(map[string]string{"dir": t.TempDir()})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("manifest with relative files", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "body.txt"), []byte("from file"), 0644)
+		manifest := map[string]interface{}{
+			"entries": []map[string]interface{}{
+				{"url": "http://api.test/a", "file": "body.txt", "headers": map[string]string{"Content-Type": "text/plain"}},
+				{"url": "http://api.test/b", "status": 404, "body": "missing"},
+				{"url": "not a url"},
+			},
+		}
+		data, _ := json.Marshal(manifest)
+		manifestPath := filepath.Join(dir, "manifest.json")
+		os.WriteFile(manifestPath, data, 0644)
+
+		rr, result := post(map[string]string{"manifest": manifestPath})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if result["loaded_count"].(float64) != 2 {
+			t.Errorf("got loaded_count %v, want 2", result["loaded_count"])
+		}
+		if failed := result["failed"].([]interface{}); len(failed) != 1 {
+			t.Errorf("got %d failures, want 1", len(failed))
+		}
+		entry, ok := api.cache.Get("http://api.test/a")
+		if !ok || string(entry.Body) != "from file" {
+			t.Errorf("expected manifest file entry, got %q (found=%v)", entry.Body, ok)
+		}
+		entry, ok = api.cache.Get("http://api.test/b")
+		if !ok || entry.StatusCode != 404 {
+			t.Errorf("expected 404 manifest entry, got %d (found=%v)", entry.StatusCode, ok)
+		}
+	})
+
+	t.Run("inline entries", func(t *testing.T) {
+		rr, result := post(map[string]interface{}{
+			"entries": []map[string]interface{}{{"url": "http://inline.test/", "body": "x"}},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if result["loaded_count"].(float64) != 1 {
+			t.Errorf("got loaded_count %v, want 1", result["loaded_count"])
+		}
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		rr, _ := post(map[string]string{})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("bad manifest", func(t *testing.T) {
+		rr, _ := post(map[string]string{"manifest": "/nonexistent/manifest.json"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// keyRequest asks for the cache key of a GET request.
+type keyRequest struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (a *ControlAPI) handleKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req keyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.URL == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+	namespace, c, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+
+	header := make(http.Header)
+	for k, v := range req.Headers {
+		header.Set(k, v)
+	}
+	key, err := a.proxy.CacheKeyForRequest(req.URL, header)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, cached := c.Peek(key)
+	a.logger.Debug("cache key computed", "url", req.URL, "key", key, "cached", cached)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":       req.URL,
+		"key":       key,
+		"namespace": namespace,
+		"cached":    cached,
+	}); err != nil {
+		a.logger.Error("failed to encode key response", "error", err)
+	}
+}
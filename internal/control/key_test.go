@@ -0,0 +1,48 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestHandleKey(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+
+	api.cache.Set("https://example.com/page?a=1", cache.CacheEntry{StatusCode: http.StatusOK})
+
+	post := func(body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		rr := httptest.NewRecorder()
+		api.handleKey(rr, httptest.NewRequest(http.MethodPost, "/key", bytes.NewReader(data)))
+		return rr
+	}
+
+	rr := post(map[string]interface{}{"url": "https://EXAMPLE.com:443/page?a=1#top"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+	}
+	var result map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result["key"] != "https://example.com/page?a=1" {
+		t.Errorf("got key %v", result["key"])
+	}
+	if result["cached"] != true {
+		t.Error("expected key to be reported as cached")
+	}
+	if api.cache.GetStats().Hits != 0 {
+		t.Error("key lookup must not count as a cache hit")
+	}
+
+	if rr := post(map[string]interface{}{"url": "ftp://example.com/"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for invalid url, want 400", rr.Code)
+	}
+	if rr := post(map[string]interface{}{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for missing url, want 400", rr.Code)
+	}
+}
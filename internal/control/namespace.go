@@ -0,0 +1,65 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// namespaceSummary is one row of the GET /namespaces response.
+type namespaceSummary struct {
+	Name           string `json:"name"`
+	EntryCount     int    `json:"entry_count"`
+	CacheSizeBytes int64  `json:"cache_size_bytes"`
+	MaxSizeBytes   int64  `json:"max_size_bytes"`
+	HitCount       uint64 `json:"hit_count"`
+	MissCount      uint64 `json:"miss_count"`
+}
+
+// cacheFor returns the cache selected by the request's ?namespace= parameter,
+// defaulting to the default namespace. It writes a 404 and returns false if
+// the namespace does not exist.
+func (a *ControlAPI) cacheFor(w http.ResponseWriter, r *http.Request) (string, *cache.MemoryCache, bool) {
+	name := r.URL.Query().Get("namespace")
+	if name == "" || name == cache.DefaultNamespace {
+		return cache.DefaultNamespace, a.cache, true
+	}
+	c, ok := a.proxy.Namespaces().Get(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown namespace %q", name), http.StatusNotFound)
+		return "", nil, false
+	}
+	return name, c, true
+}
+
+func (a *ControlAPI) handleNamespaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	registry := a.proxy.Namespaces()
+	summaries := []namespaceSummary{}
+	for _, name := range registry.Names() {
+		c, ok := registry.Get(name)
+		if !ok {
+			continue
+		}
+		stats := c.GetStats()
+		summaries = append(summaries, namespaceSummary{
+			Name:           name,
+			EntryCount:     stats.EntryCount,
+			CacheSizeBytes: stats.TotalSize,
+			MaxSizeBytes:   stats.MaxSize,
+			HitCount:       stats.Hits,
+			MissCount:      stats.Misses,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"namespaces": summaries,
+	}); err != nil {
+		a.logger.Error("failed to encode namespaces response", "error", err)
+	}
+}
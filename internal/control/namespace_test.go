@@ -0,0 +1,91 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestNamespaceScoping(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+
+	project := cache.NewMemoryCache(time.Minute, 0)
+	defer project.Shutdown()
+	api.proxy.Namespaces().Add("project", project)
+
+	entry := cache.CacheEntry{StatusCode: http.StatusOK, Body: []byte("x")}
+	api.cache.Set("http://example.com/default", entry)
+	project.Set("http://example.com/a", entry)
+	project.Set("http://example.com/b", entry)
+
+	t.Run("stats", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		api.handleStats(rr, httptest.NewRequest(http.MethodGet, "/stats?namespace=project", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		var stats map[string]interface{}
+		json.NewDecoder(rr.Body).Decode(&stats)
+		if stats["namespace"] != "project" || stats["entry_count"] != float64(2) {
+			t.Errorf("unexpected stats: %v", stats)
+		}
+	})
+
+	t.Run("unknown namespace", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		api.handleStats(rr, httptest.NewRequest(http.MethodGet, "/stats?namespace=nope", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("got status %d, want 404", rr.Code)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		api.handleNamespaces(rr, httptest.NewRequest(http.MethodGet, "/namespaces", nil))
+		var result struct {
+			Namespaces []namespaceSummary `json:"namespaces"`
+		}
+		json.NewDecoder(rr.Body).Decode(&result)
+		if len(result.Namespaces) != 2 {
+			t.Fatalf("got %d namespaces, want 2", len(result.Namespaces))
+		}
+		if result.Namespaces[1].Name != "project" || result.Namespaces[1].EntryCount != 2 {
+			t.Errorf("unexpected summary: %+v", result.Namespaces[1])
+		}
+	})
+
+	t.Run("inject into namespace", func(t *testing.T) {
+		data, _ := json.Marshal(map[string]string{"url": "http://example.com/injected", "body": "y"})
+		rr := httptest.NewRecorder()
+		api.handleEntry(rr, httptest.NewRequest(http.MethodPut, "/entry?namespace=project", bytes.NewReader(data)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if _, ok := project.Get("http://example.com/injected"); !ok {
+			t.Error("expected entry in project namespace")
+		}
+		if _, ok := api.cache.Get("http://example.com/injected"); ok {
+			t.Error("entry must not be stored in the default namespace")
+		}
+	})
+
+	t.Run("purge all only clears the namespace", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		api.handlePurgeAll(rr, httptest.NewRequest(http.MethodPost, "/purge/all?namespace=project", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if project.GetStats().EntryCount != 0 {
+			t.Error("expected project namespace to be empty")
+		}
+		if _, ok := api.cache.Get("http://example.com/default"); !ok {
+			t.Error("default namespace must be untouched")
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gbmerrall/gocache/internal/warm"
+)
+
+type warmRequest struct {
+	URLs        []string `json:"urls"`
+	Sitemaps    []string `json:"sitemaps"`
+	Concurrency int      `json:"concurrency"`   // 0 uses the configured default
+	RatePerHost *float64 `json:"rate_per_host"` // nil uses the configured default
+}
+
+func (a *ControlAPI) handleWarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req warmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(req.URLs) == 0 && len(req.Sitemaps) == 0 {
+		http.Error(w, "urls or sitemaps are required", http.StatusBadRequest)
+		return
+	}
+	namespace, _, ok := a.cacheFor(w, r)
+	if !ok {
+		return
+	}
+
+	opts := warm.Options{
+		Concurrency: a.config.Warm.Concurrency,
+		RatePerHost: a.config.Warm.RatePerHost,
+	}
+	if req.Concurrency > 0 {
+		opts.Concurrency = req.Concurrency
+	}
+	if req.RatePerHost != nil {
+		if *req.RatePerHost < 0 {
+			http.Error(w, "rate_per_host must not be negative", http.StatusBadRequest)
+			return
+		}
+		opts.RatePerHost = *req.RatePerHost
+	}
+
+	job := a.warmer.Start(warm.Request{
+		Namespace: namespace,
+		URLs:      req.URLs,
+		Sitemaps:  req.Sitemaps,
+		Options:   opts,
+	})
+	a.logger.Info("warm job queued", "id", job.ID(), "namespace", namespace, "urls", len(req.URLs), "sitemaps", len(req.Sitemaps))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"job_id":     job.ID(),
+		"status_url": "/warm/status?id=" + job.ID(),
+	}); err != nil {
+		a.logger.Error("failed to encode warm response", "error", err)
+	}
+}
+
+func (a *ControlAPI) handleWarmStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response interface{}
+	if id := r.URL.Query().Get("id"); id != "" {
+		job, ok := a.warmer.Get(id)
+		if !ok {
+			http.Error(w, "Warm job not found", http.StatusNotFound)
+			return
+		}
+		response = job.Status()
+	} else {
+		response = map[string]interface{}{"jobs": a.warmer.List()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		a.logger.Error("failed to encode warm status response", "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/warm"
+)
+
+func TestHandleWarm(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<urlset><url><loc>` + "http://" + r.Host + `/b</loc></url></urlset>`))
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("page " + r.URL.Path))
+		}
+	}))
+	defer upstream.Close()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"urls":        []string{upstream.URL + "/a", upstream.URL + "/missing"},
+		"sitemaps":    []string{upstream.URL + "/sitemap.xml"},
+		"concurrency": 2,
+	})
+	rr := httptest.NewRecorder()
+	api.handleWarm(rr, httptest.NewRequest(http.MethodPost, "/warm", bytes.NewReader(body)))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	var started map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &started)
+	id := started["job_id"]
+	if id == "" {
+		t.Fatal("expected a job id")
+	}
+
+	var status warm.Status
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr = httptest.NewRecorder()
+		api.handleWarmStatus(rr, httptest.NewRequest(http.MethodGet, "/warm/status?id="+id, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		json.Unmarshal(rr.Body.Bytes(), &status)
+		if status.State != warm.StateRunning || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status.State != warm.StateCompleted {
+		t.Fatalf("got state %q, want %q", status.State, warm.StateCompleted)
+	}
+	if status.Succeeded != 2 || status.Failed != 1 {
+		t.Errorf("got %d succeeded and %d failed, want 2 and 1", status.Succeeded, status.Failed)
+	}
+	for _, path := range []string{"/a", "/b"} {
+		if _, ok := api.cache.Get(upstream.URL + path); !ok {
+			t.Errorf("expected %s to be warmed into the cache", path)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	api.handleWarmStatus(rr, httptest.NewRequest(http.MethodGet, "/warm/status", nil))
+	var list map[string][]warm.Status
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list["jobs"]) != 1 {
+		t.Errorf("expected 1 job in list, got %d", len(list["jobs"]))
+	}
+}
+
+func TestHandleWarmErrors(t *testing.T) {
+	api, cleanup := setupTestAPI(t)
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"wrong method", http.MethodGet, "/warm", "", http.StatusMethodNotAllowed},
+		{"invalid json", http.MethodPost, "/warm", "{", http.StatusBadRequest},
+		{"nothing to warm", http.MethodPost, "/warm", `{"urls":[]}`, http.StatusBadRequest},
+		{"negative rate", http.MethodPost, "/warm", `{"urls":["http://a.test/"],"rate_per_host":-1}`, http.StatusBadRequest},
+		{"status wrong method", http.MethodPost, "/warm/status", "", http.StatusMethodNotAllowed},
+		{"unknown job", http.MethodGet, "/warm/status?id=999", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			if req.URL.Path == "/warm" {
+				api.handleWarm(rr, req)
+			} else {
+				api.handleWarmStatus(rr, req)
+			}
+			if rr.Code != tt.want {
+				t.Errorf("got status %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
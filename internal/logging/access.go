@@ -21,6 +21,8 @@ type AccessLogEntry struct {
 	Duration    int64 // Response time in milliseconds
 	URL         string
 	ContentType string
+	Rule        string // Name of the matching cache rule, if any
+	RequestSize int64  // Bytes received from the client, logged for tunnels
 }
 
 // AccessLogFormat represents the output format for access logs
@@ -232,7 +234,7 @@ func (al *AccessLogger) writeEntry(entry AccessLogEntry) {
 
 // formatHuman formats the entry as space-separated fields
 func (al *AccessLogger) formatHuman(entry AccessLogEntry) string {
-	// Format: timestamp cache_status status method size duration_ms url content_type
+	// Format: timestamp cache_status status method size duration_ms url content_type [rule=name] [request_size=n]
 	timestamp := entry.Timestamp.Format(time.RFC3339)
 	cacheStatus := entry.CacheStatus
 	if cacheStatus == "" {
@@ -243,7 +245,7 @@ func (al *AccessLogger) formatHuman(entry AccessLogEntry) string {
 		contentType = `""`
 	}
 
-	return fmt.Sprintf("%s %s %d %s %d %d %s %s",
+	line := fmt.Sprintf("%s %s %d %s %d %d %s %s",
 		timestamp,
 		cacheStatus,
 		entry.Status,
@@ -253,6 +255,13 @@ func (al *AccessLogger) formatHuman(entry AccessLogEntry) string {
 		entry.URL,
 		contentType,
 	)
+	if entry.Rule != "" {
+		line += " rule=" + entry.Rule
+	}
+	if entry.RequestSize > 0 {
+		line += fmt.Sprintf(" request_size=%d", entry.RequestSize)
+	}
+	return line
 }
 
 // formatJSON formats the entry as JSON
@@ -266,6 +275,8 @@ func (al *AccessLogger) formatJSON(entry AccessLogEntry) (string, error) {
 		DurationMs  int64  `json:"duration_ms"`
 		URL         string `json:"url"`
 		ContentType string `json:"content_type"`
+		Rule        string `json:"rule,omitempty"`
+		RequestSize int64  `json:"request_size,omitempty"`
 	}{
 		Timestamp:   entry.Timestamp.Format(time.RFC3339),
 		CacheStatus: entry.CacheStatus,
@@ -275,6 +286,8 @@ func (al *AccessLogger) formatJSON(entry AccessLogEntry) (string, error) {
 		DurationMs:  entry.Duration,
 		URL:         entry.URL,
 		ContentType: entry.ContentType,
+		Rule:        entry.Rule,
+		RequestSize: entry.RequestSize,
 	}
 
 	data, err := json.Marshal(jsonEntry)
@@ -312,6 +325,12 @@ func (crw *CountingResponseWriter) WriteHeader(statusCode int) {
 	crw.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap returns the underlying ResponseWriter, so that
+// http.ResponseController can reach its Flush method
+func (crw *CountingResponseWriter) Unwrap() http.ResponseWriter {
+	return crw.ResponseWriter
+}
+
 // StatusCode returns the HTTP status code
 func (crw *CountingResponseWriter) StatusCode() int {
 	return crw.statusCode
@@ -144,6 +144,66 @@ func TestAccessLoggerJSONFormat(t *testing.T) {
 	}
 }
 
+func TestAccessLoggerRuleField(t *testing.T) {
+	logger := &AccessLogger{}
+	entry := AccessLogEntry{
+		Timestamp:   time.Date(2024, 8, 18, 14, 30, 45, 0, time.UTC),
+		CacheStatus: "HIT",
+		Status:      200,
+		Method:      "GET",
+		Size:        10,
+		Duration:    1,
+		URL:         "https://example.com/api/data",
+		ContentType: "application/json",
+		Rule:        "api",
+	}
+
+	expected := "2024-08-18T14:30:45Z HIT 200 GET 10 1 https://example.com/api/data application/json rule=api"
+	if line := logger.formatHuman(entry); line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+
+	line, err := logger.formatJSON(entry)
+	if err != nil {
+		t.Fatalf("failed to format JSON: %v", err)
+	}
+	if !strings.Contains(line, `"rule":"api"`) {
+		t.Errorf("expected rule in JSON entry, got %s", line)
+	}
+
+	entry.Rule = ""
+	if line, _ := logger.formatJSON(entry); strings.Contains(line, `"rule"`) {
+		t.Errorf("expected rule to be omitted when empty, got %s", line)
+	}
+}
+
+func TestAccessLoggerRequestSize(t *testing.T) {
+	logger := &AccessLogger{}
+	entry := AccessLogEntry{
+		Timestamp:   time.Date(2024, 8, 18, 14, 30, 45, 0, time.UTC),
+		CacheStatus: "TUNNEL",
+		Status:      200,
+		Method:      "CONNECT",
+		Size:        4096,
+		Duration:    250,
+		URL:         "example.com:443",
+		RequestSize: 512,
+	}
+
+	expected := `2024-08-18T14:30:45Z TUNNEL 200 CONNECT 4096 250 example.com:443 "" request_size=512`
+	if line := logger.formatHuman(entry); line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+
+	line, err := logger.formatJSON(entry)
+	if err != nil {
+		t.Fatalf("failed to format JSON: %v", err)
+	}
+	if !strings.Contains(line, `"request_size":512`) {
+		t.Errorf("expected request_size in JSON entry, got %s", line)
+	}
+}
+
 func TestAccessLoggerEmptyFields(t *testing.T) {
 	tmpDir := t.TempDir()
 	logFile := filepath.Join(tmpDir, "access.log")
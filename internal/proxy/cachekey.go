@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+// keyRules is the effective set of cache-key rules for one request.
+type keyRules struct {
+	ignoreParams      []string
+	headers           []string
+	cookies           []string
+	normalizeHost     bool
+	stripDefaultPort  bool
+	collapseSlashes   bool
+	normalizeEncoding bool
+	keyExtra          string // From the X-GoCache-Key-Extra request header
+}
+
+// apply merges r into k. Lists are appended and set booleans override.
+func (k *keyRules) apply(r config.CacheKeyRules) {
+	k.ignoreParams = append(k.ignoreParams, r.IgnoreParams...)
+	k.headers = append(k.headers, r.Headers...)
+	k.cookies = append(k.cookies, r.Cookies...)
+	if r.CollapseSlashes != nil {
+		k.collapseSlashes = *r.CollapseSlashes
+	}
+	if r.NormalizeEncoding != nil {
+		k.normalizeEncoding = *r.NormalizeEncoding
+	}
+}
+
+// keyRulesFor returns the global key rules plus those of every domain entry
+// matching r's host and of the cache rule matching r.
+func (p *Proxy) keyRulesFor(r *http.Request) keyRules {
+	cfg := p.config.Cache.Key
+	rules := keyRules{
+		ignoreParams:      append([]string(nil), cfg.IgnoreParams...),
+		headers:           append([]string(nil), cfg.Headers...),
+		cookies:           append([]string(nil), cfg.Cookies...),
+		normalizeHost:     cfg.NormalizeHost,
+		stripDefaultPort:  cfg.StripDefaultPort,
+		collapseSlashes:   cfg.CollapseSlashes,
+		normalizeEncoding: cfg.NormalizeEncoding,
+	}
+	host := r.URL.Hostname()
+	for _, d := range cfg.Domains {
+		if config.MatchHost([]string{d.Host}, host) {
+			rules.apply(d.CacheKeyRules)
+		}
+	}
+	if rule := p.ruleFor(r); rule != nil {
+		rules.apply(rule.Key)
+	}
+	rules.keyExtra = controlsFrom(r).keyExtra
+	return rules
+}
+
+// cacheKey returns the cache key for r under the configured normalization rules.
+func (p *Proxy) cacheKey(r *http.Request) string {
+	return buildCacheKey(r, p.keyRulesFor(r))
+}
+
+// buildCacheKey creates a normalized cache key from a request's URL. The
+// fragment is dropped and query parameters are sorted; rules may further
+// normalize the URL. Folded headers and cookies are appended as a fragment so
+// the key still parses as a URL.
+func buildCacheKey(r *http.Request, rules keyRules) string {
+	u := *r.URL
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if rules.normalizeHost || rules.stripDefaultPort {
+		u.Host = normalizeHostPort(u.Scheme, u.Host, rules.normalizeHost, rules.stripDefaultPort)
+	}
+
+	if rules.normalizeEncoding || rules.collapseSlashes {
+		escaped := u.EscapedPath()
+		if rules.normalizeEncoding {
+			escaped = normalizePercentEncoding(escaped)
+		}
+		if rules.collapseSlashes {
+			for strings.Contains(escaped, "//") {
+				escaped = strings.ReplaceAll(escaped, "//", "/")
+			}
+		}
+		if unescaped, err := url.PathUnescape(escaped); err == nil {
+			u.Path = unescaped
+			u.RawPath = escaped
+		}
+	}
+
+	q := u.Query()
+	if len(q) > 0 {
+		for name := range q {
+			if matchAny(rules.ignoreParams, name) {
+				delete(q, name)
+			}
+		}
+		// Encode sorts by parameter name.
+		u.RawQuery = q.Encode()
+	}
+
+	key := u.String()
+	if vary := varyKeyPart(r, rules); vary != "" {
+		key += "#" + vary
+	}
+	return key
+}
+
+// normalizeHostPort lowercases and punycode-encodes the host and drops the
+// port when it is the scheme's default.
+func normalizeHostPort(scheme, hostport string, normalizeHost, stripDefaultPort bool) string {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+	}
+	if normalizeHost {
+		host = strings.ToLower(host)
+		if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+			host = ascii
+		}
+	}
+	if stripDefaultPort && ((scheme == "http" && port == "80") || (scheme == "https" && port == "443")) {
+		port = ""
+	}
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]" // IPv6 literal
+		}
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and
+// uppercases the hex digits of all other escapes, per RFC 3986 section 6.2.2.
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			c := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(c) {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('%')
+				b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+			}
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// matchAny reports whether name matches any of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// varyKeyPart encodes the configured request headers and cookies, sorted by
+// name, as "h:name=value&c:name=value", plus "x=value" for a key extra.
+// Missing values are encoded as empty.
+func varyKeyPart(r *http.Request, rules keyRules) string {
+	if len(rules.headers) == 0 && len(rules.cookies) == 0 && rules.keyExtra == "" {
+		return ""
+	}
+	var parts []string
+	if rules.keyExtra != "" {
+		parts = append(parts, keyExtraPart(rules.keyExtra))
+	}
+	seen := make(map[string]bool)
+	for _, name := range rules.headers {
+		name = strings.ToLower(name)
+		if seen["h:"+name] {
+			continue
+		}
+		seen["h:"+name] = true
+		value := strings.Join(r.Header.Values(name), ",")
+		parts = append(parts, "h:"+name+"="+url.QueryEscape(value))
+	}
+	for _, name := range rules.cookies {
+		if seen["c:"+name] {
+			continue
+		}
+		seen["c:"+name] = true
+		var value string
+		if c, err := r.Cookie(name); err == nil {
+			value = c.Value
+		}
+		parts = append(parts, "c:"+name+"="+url.QueryEscape(value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
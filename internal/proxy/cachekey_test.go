@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+func TestCacheKeyNormalization(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	yes := true
+	proxy.config.Cache.Key.IgnoreParams = []string{"utm_*", "fbclid"}
+	proxy.config.Cache.Key.Domains = []config.CacheKeyDomain{
+		{Host: "*.example.org", CacheKeyRules: config.CacheKeyRules{
+			IgnoreParams:    []string{"_"},
+			CollapseSlashes: &yes,
+			Headers:         []string{"Accept-Language"},
+			Cookies:         []string{"variant"},
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		header   http.Header
+		expected string
+	}{
+		{"lowercase host", "http://EXAMPLE.com/Page", nil, "http://example.com/Page"},
+		{"strip default http port", "http://example.com:80/", nil, "http://example.com/"},
+		{"strip default https port", "https://example.com:443/", nil, "https://example.com/"},
+		{"keep other ports", "https://example.com:8443/", nil, "https://example.com:8443/"},
+		{"idn host", "http://bücher.example/", nil, "http://xn--bcher-kva.example/"},
+		{"ignored params", "http://example.com/?utm_source=x&b=2&fbclid=y&a=1", nil, "http://example.com/?a=1&b=2"},
+		{"all params ignored", "http://example.com/?utm_source=x", nil, "http://example.com/"},
+		{"percent encoding", "http://example.com/%7euser/a%2fb", nil, "http://example.com/~user/a%2Fb"},
+		{"slashes kept by default", "http://example.com//a//b", nil, "http://example.com//a//b"},
+		{"domain rules", "http://www.example.org//a//b?_=123&x=1", http.Header{
+			"Accept-Language": {"en"},
+			"Cookie":          {"variant=B; other=1"},
+		}, "http://www.example.org/a/b?x=1#c:variant=B&h:accept-language=en"},
+		{"domain rules with missing values", "http://www.example.org/", nil, "http://www.example.org/#c:variant=&h:accept-language="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			if got := proxy.cacheKey(req); got != tt.expected {
+				t.Errorf("cacheKey(%s) = %s, want %s", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCacheKeyVariesByHeader(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.Key.Headers = []string{"Accept-Language"}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	defer server.Close()
+
+	get := func(lang string) string {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/greeting", nil)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if get("en") != "en" || get("de") != "de" || get("en") != "en" {
+		t.Error("expected each language to get its own response")
+	}
+	if requests != 2 {
+		t.Errorf("got %d upstream requests, want 2", requests)
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// validatorHeaders are removed from cacheable requests before they are
+// forwarded, so that upstream sends the full response for the cache rather
+// than a 304. The proxy evaluates them against the complete response once it
+// is stored; a response that is not stored is requested again with them.
+var validatorHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// takeValidators removes the validator headers from r if its response may be
+// stored, and returns their values so they can be restored with
+// restoreHeaders.
+func (p *Proxy) takeValidators(r *http.Request) http.Header {
+	if !p.shouldCacheRequest(r) || !p.mayStore(r) {
+		return nil
+	}
+	var saved http.Header
+	for _, name := range validatorHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			if saved == nil {
+				saved = make(http.Header)
+			}
+			saved[name] = values
+			r.Header.Del(name)
+		}
+	}
+	return saved
+}
+
+// notModified reports whether the validators of a conditional GET or HEAD
+// request r match a cached 200 entry, so the client's copy is current and a
+// 304 can be sent instead of the body. If-None-Match takes precedence over
+// If-Modified-Since (RFC 9110 section 13.2.2).
+func notModified(r *http.Request, entry cache.CacheEntry) bool {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || entry.StatusCode != http.StatusOK {
+		return false
+	}
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		return etagMatches(values, entry.Headers.Get("ETag"))
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(entry.Headers.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// etagMatches reports whether the If-None-Match values match etag, using the
+// weak comparison. "*" matches any cached representation.
+func etagMatches(values []string, etag string) bool {
+	current := opaqueTag(etag)
+	for _, v := range values {
+		for v = strings.TrimSpace(v); v != ""; v = strings.TrimLeft(v, " \t,") {
+			if v[0] == '*' {
+				return true
+			}
+			tag, rest, ok := scanETag(v)
+			if !ok {
+				break
+			}
+			if current != "" && opaqueTag(tag) == current {
+				return true
+			}
+			v = rest
+		}
+	}
+	return false
+}
+
+// scanETag splits the entity tag at the start of s from the rest of s.
+func scanETag(s string) (tag, rest string, ok bool) {
+	opaque := strings.TrimPrefix(s, "W/")
+	if len(opaque) < 2 || opaque[0] != '"' {
+		return "", s, false
+	}
+	end := strings.IndexByte(opaque[1:], '"')
+	if end < 0 {
+		return "", s, false
+	}
+	n := len(s) - len(opaque) + end + 2
+	return s[:n], s[n:], true
+}
+
+// opaqueTag returns an entity tag without its weak indicator.
+func opaqueTag(tag string) string {
+	return strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+}
+
+// writeNotModified sends a 304 response with the headers set in w, minus
+// those that only describe a body.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Range"} {
+		h.Del(name)
+	}
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// withETag returns entry with an ETag derived from its body if
+// synthesize_etag is enabled and the upstream 200 response has none. The tag
+// is weak: the body is hashed decoded but may be sent in any content coding,
+// and a strong tag must differ between codings (RFC 9110 section 8.8.3).
+// notModified compares it weakly, and If-Range, which needs a strong match,
+// falls back to the full response.
+func (p *Proxy) withETag(entry cache.CacheEntry) cache.CacheEntry {
+	if !p.config.Cache.SynthesizeETag || entry.StatusCode != http.StatusOK || entry.Headers.Get("ETag") != "" {
+		return entry
+	}
+	sum := sha256.Sum256(entry.Body)
+	if entry.Headers == nil {
+		entry.Headers = http.Header{}
+	} else {
+		entry.Headers = entry.Headers.Clone()
+	}
+	entry.Headers.Set("ETag", `W/"`+hex.EncodeToString(sum[:16])+`"`)
+	return entry
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestNotModified(t *testing.T) {
+	entry := cache.CacheEntry{
+		StatusCode: http.StatusOK,
+		Headers: http.Header{
+			"Etag":          {`W/"v1"`},
+			"Last-Modified": {"Wed, 01 Jan 2025 00:00:00 GMT"},
+		},
+	}
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		entry  cache.CacheEntry
+		want   bool
+	}{
+		{"etag match", http.MethodGet, http.Header{"If-None-Match": {`"v1"`}}, entry, true},
+		{"etag in list", http.MethodGet, http.Header{"If-None-Match": {`"v0", W/"v1"`}}, entry, true},
+		{"etag with comma", http.MethodGet, http.Header{"If-None-Match": {`"a,b", "v2"`}}, entry, false},
+		{"etag mismatch", http.MethodGet, http.Header{"If-None-Match": {`"v2"`}}, entry, false},
+		{"wildcard", http.MethodHead, http.Header{"If-None-Match": {"*"}}, entry, true},
+		{"if-none-match wins", http.MethodGet, http.Header{"If-None-Match": {`"v2"`}, "If-Modified-Since": {"Thu, 01 Jan 2026 00:00:00 GMT"}}, entry, false},
+		{"not modified since", http.MethodGet, http.Header{"If-Modified-Since": {"Wed, 01 Jan 2025 00:00:00 GMT"}}, entry, true},
+		{"modified since", http.MethodGet, http.Header{"If-Modified-Since": {"Tue, 31 Dec 2024 00:00:00 GMT"}}, entry, false},
+		{"invalid date", http.MethodGet, http.Header{"If-Modified-Since": {"yesterday"}}, entry, false},
+		{"unconditional", http.MethodGet, http.Header{}, entry, false},
+		{"post", http.MethodPost, http.Header{"If-None-Match": {`"v1"`}}, entry, false},
+		{"error entry", http.MethodGet, http.Header{"If-None-Match": {"*"}}, cache.CacheEntry{StatusCode: http.StatusNotFound}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com/", nil)
+			r.Header = tt.header
+			if got := notModified(r, tt.entry); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var validators []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		validators = append(validators, r.Header.Get("If-None-Match")+r.Header.Get("If-Modified-Since"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 Jan 2025 00:00:00 GMT")
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	get("/page", nil)
+
+	t.Run("matching etag", func(t *testing.T) {
+		w := get("/page", http.Header{"If-None-Match": {`"v1"`}})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("got %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+		}
+		if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("ETag") != `"v1"` {
+			t.Errorf("expected X-Cache HIT and the ETag, got %v", w.Header())
+		}
+		if w.Header().Get("Content-Type") != "" || w.Header().Get("Content-Length") != "" {
+			t.Error("expected no body headers on a 304")
+		}
+	})
+
+	t.Run("stale etag", func(t *testing.T) {
+		w := get("/page", http.Header{"If-None-Match": {`"v0"`}})
+		if w.Code != http.StatusOK || w.Body.String() != "content" {
+			t.Errorf("got %d %q, want the full response", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("if-modified-since", func(t *testing.T) {
+		w := get("/page", http.Header{"If-Modified-Since": {"Thu, 02 Jan 2025 00:00:00 GMT"}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("got %d, want 304", w.Code)
+		}
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		w := get("/other", http.Header{"If-None-Match": {`"v1"`}})
+		if w.Code != http.StatusNotModified || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("got %d with X-Cache %q, want a 304 MISS", w.Code, w.Header().Get("X-Cache"))
+		}
+		if last := validators[len(validators)-1]; last != "" {
+			t.Errorf("expected validators not to be forwarded, upstream got %q", last)
+		}
+		w = get("/other", nil)
+		if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "content" {
+			t.Errorf("expected the full response to be cached, got X-Cache %q and %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+	})
+}
+
+func TestConditionalRequestsHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("secure content"))
+	}))
+	defer server.Close()
+
+	client := newMITMClient(t, proxy)
+	for _, want := range []int{http.StatusOK, http.StatusNotModified} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+		if want == http.StatusNotModified {
+			req.Header.Set("If-None-Match", `"v1"`)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("got status %d, want %d", resp.StatusCode, want)
+		}
+		if want == http.StatusNotModified && len(body) != 0 {
+			t.Errorf("expected no body on a 304, got %q", body)
+		}
+	}
+}
+
+func TestSynthesizeETag(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/tagged" {
+			w.Header().Set("ETag", `"upstream"`)
+		}
+		w.Write([]byte("content " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	get("/off", "")
+	if etag := get("/off", "").Header().Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag while synthesize_etag is off, got %q", etag)
+	}
+
+	proxy.config.Cache.SynthesizeETag = true
+	get("/on", "")
+	etag := get("/on", "").Header().Get("ETag")
+	if len(etag) != 36 || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected a weak synthesized ETag, got %q", etag)
+	}
+	if w := get("/on", etag); w.Code != http.StatusNotModified {
+		t.Errorf("got %d for the synthesized ETag, want 304", w.Code)
+	}
+	if w := get("/on", strings.TrimPrefix(etag, "W/")); w.Code != http.StatusNotModified {
+		t.Errorf("got %d for the synthesized ETag without W/, want 304", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, server.URL+"/on", nil)
+	req.Header.Set("Range", "bytes=0-1")
+	req.Header.Set("If-Range", etag)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d for If-Range with a weak ETag, want the full 200", w.Code)
+	}
+	get("/other", "")
+	if other := get("/other", "").Header().Get("ETag"); other == etag {
+		t.Error("expected different bodies to get different ETags")
+	}
+
+	get("/tagged", "")
+	if got := get("/tagged", "").Header().Get("ETag"); got != `"upstream"` {
+		t.Errorf("expected the upstream ETag to be kept, got %q", got)
+	}
+}
+
+func TestConditionalRequestsNotStored(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.cache.Shutdown()
+	proxy.cache = cache.NewMemoryCache(time.Minute, 1)
+	proxy.namespaces = nil
+
+	content := bytes.Repeat([]byte("0123456789"), 3*1024*1024/10)
+	var mu sync.Mutex
+	var validators []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		validators = append(validators, r.Header.Get("If-None-Match"))
+		mu.Unlock()
+		if r.URL.Path == "/video" {
+			w.Header().Set("Content-Type", "video/mp4")
+		} else {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// Video is not a cacheable type and /big exceeds the 1 MB cache, so the
+	// validators must reach the origin and its 304 be relayed
+	for _, path := range []string{"/video", "/big"} {
+		t.Run(path, func(t *testing.T) {
+			mu.Lock()
+			validators = nil
+			mu.Unlock()
+
+			req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+			req.Header.Set("If-None-Match", `"v1"`)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("got %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(validators) == 0 || validators[len(validators)-1] != `"v1"` {
+				t.Errorf("got upstream If-None-Match %q, want the client's validator forwarded", validators)
+			}
+		})
+	}
+	if n := proxy.cache.GetStats().EntryCount; n != 0 {
+		t.Errorf("got %d cache entries, want none", n)
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// connResponseWriter is an http.ResponseWriter that writes an HTTP/1.1
+// response to a hijacked client connection. Bodies without a Content-Length
+// are sent chunked, so a response can be streamed before its length is known.
+type connResponseWriter struct {
+	w           *bufio.Writer
+	req         *http.Request
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	noBody      bool // HEAD request or a status that has no body
+	chunked     bool
+	size        int64 // Body bytes written
+}
+
+// hopHeaders describe a single connection, so those copied from an upstream
+// response must not reach the client (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the hop-by-hop headers from h, including those
+// listed in its Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func newConnResponseWriter(conn io.Writer, req *http.Request) *connResponseWriter {
+	return &connResponseWriter{
+		w:      bufio.NewWriter(conn),
+		req:    req,
+		header: make(http.Header),
+	}
+}
+
+func (cw *connResponseWriter) Header() http.Header { return cw.header }
+
+func (cw *connResponseWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.statusCode = statusCode
+	cw.noBody = cw.req.Method == http.MethodHead || statusCode < 200 ||
+		statusCode == http.StatusNoContent || statusCode == http.StatusNotModified
+
+	h := cw.header.Clone()
+	removeHopHeaders(h)
+	if !keepAlive(cw.req) {
+		h.Set("Connection", "close")
+	}
+	if !cw.noBody && h.Get("Content-Length") == "" {
+		cw.chunked = true
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	fmt.Fprintf(cw.w, "HTTP/1.1 %03d %s\r\n", statusCode, http.StatusText(statusCode))
+	h.Write(cw.w)
+	cw.w.WriteString("\r\n")
+}
+
+func (cw *connResponseWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.noBody {
+		return len(p), nil
+	}
+	if !cw.chunked {
+		n, err := cw.w.Write(p)
+		cw.size += int64(n)
+		return n, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	cw.w.WriteString(strconv.FormatInt(int64(len(p)), 16) + "\r\n")
+	n, err := cw.w.Write(p)
+	cw.size += int64(n)
+	if err == nil {
+		_, err = cw.w.WriteString("\r\n")
+	}
+	return n, err
+}
+
+// Flush sends any buffered data to the client.
+func (cw *connResponseWriter) Flush() {
+	cw.w.Flush()
+}
+
+// finish completes the response and flushes it to the client. A response
+// that is abandoned without calling finish is left truncated, which the client
+// detects when the connection is closed.
+func (cw *connResponseWriter) finish() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.chunked {
+		cw.w.WriteString("0\r\n\r\n")
+	}
+	return cw.w.Flush()
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+// responseMediaType returns the lowercased media type of resp without
+// parameters. When the response has no Content-Type and sniffing is enabled,
+// the type is detected from body.
+func (p *Proxy) responseMediaType(resp *http.Response, body []byte) string {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" && p.config.Cache.SniffContentType && len(body) > 0 {
+		contentType = http.DetectContentType(body)
+		p.logger.Debug("sniffed content type", "contentType", contentType)
+	}
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Fall back to the part before any parameters for malformed headers.
+		mediaType, _, _ = strings.Cut(contentType, ";")
+		mediaType = strings.TrimSpace(mediaType)
+	}
+	return strings.ToLower(mediaType)
+}
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// sniffPrefix returns the start of the body buffered in br when resp has no
+// Content-Type and sniffing is enabled, for use with responseMediaType. The
+// bytes remain in br.
+func (p *Proxy) sniffPrefix(resp *http.Response, br *bufio.Reader) []byte {
+	if resp.Header.Get("Content-Type") != "" || !p.config.Cache.SniffContentType {
+		return nil
+	}
+	prefix, _ := br.Peek(sniffLen)
+	return decodedPrefix(contentCoding(resp.Header), prefix)
+}
+
+// decodedPrefix decodes the start of a body in the given content coding, so
+// the type is sniffed from the bytes canonicalEntry stores rather than from
+// compressed data. It returns nil for a coding the proxy cannot decode.
+func decodedPrefix(coding string, data []byte) []byte {
+	if coding == "" {
+		return data
+	}
+	if !canDecode(coding) {
+		return nil
+	}
+	r, err := newDecoder(coding, bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	// The data is usually cut off mid-stream, so keep whatever decoded
+	prefix, _ := io.ReadAll(io.LimitReader(r, sniffLen))
+	return prefix
+}
+
+// isCacheableType reports whether a media type may be cached under rule.
+// uncacheable_types is checked first and always wins.
+func (p *Proxy) isCacheableType(rule *config.CacheRule, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range p.config.Cache.UncacheableTypes {
+		if matchMediaType(pattern, mediaType) {
+			return false
+		}
+	}
+	for _, pattern := range p.cacheableTypes(rule) {
+		if matchMediaType(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMediaType reports whether mediaType matches pattern. Patterns may be an
+// exact type ("text/html"), a wildcard ("image/*", "*/*", "application/*+json")
+// or a bare type prefix ending in a slash ("image/").
+func matchMediaType(pattern, mediaType string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "*"
+	}
+	if !strings.Contains(pattern, "*") {
+		return pattern == mediaType
+	}
+	ok, _ := path.Match(pattern, mediaType)
+	return ok
+}
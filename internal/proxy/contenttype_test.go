@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchMediaType(t *testing.T) {
+	tests := []struct {
+		pattern, mediaType string
+		want               bool
+	}{
+		{"text/html", "text/html", true},
+		{"text/html", "text/htmlx", false},
+		{"TEXT/HTML", "text/html", true},
+		{"image/*", "image/png", true},
+		{"image/*", "text/png", false},
+		{"image/", "image/webp", true},
+		{"*/*", "application/pdf", true},
+		{"application/*+json", "application/ld+json", true},
+		{"application/*+json", "application/json", false},
+	}
+	for _, tt := range tests {
+		if got := matchMediaType(tt.pattern, tt.mediaType); got != tt.want {
+			t.Errorf("matchMediaType(%q, %q) = %v, want %v", tt.pattern, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestCacheableContentTypes(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	proxy.config.Cache.CacheableTypes = []string{"text/*", "image/*", "application/json"}
+	proxy.config.Cache.UncacheableTypes = []string{"text/event-stream", "image/svg+xml"}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	check := func(contentType string, body []byte) bool {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		if contentType != "" {
+			resp.Header.Set("Content-Type", contentType)
+		}
+		return proxy.shouldCacheResponse(req, resp, body)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        bool
+	}{
+		{"wildcard", "image/png", true},
+		{"parameters ignored", "Text/HTML; charset=UTF-8", true},
+		{"deny list wins", "text/event-stream", false},
+		{"deny list with parameters", "image/svg+xml; charset=utf-8", false},
+		{"exact match only", "application/json-seq", false},
+		{"malformed parameters", "application/json; charset", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := check(tt.contentType, nil); got != tt.want {
+				t.Errorf("shouldCacheResponse(%q) = %v, want %v", tt.contentType, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("sniffing", func(t *testing.T) {
+		html := []byte("<!DOCTYPE html><html><body>hi</body></html>")
+		if check("", html) {
+			t.Error("expected response without Content-Type to be skipped when sniffing is disabled")
+		}
+		proxy.config.Cache.SniffContentType = true
+		if !check("", html) {
+			t.Error("expected sniffed text/html to be cached")
+		}
+		if check("", []byte{0x00, 0x01, 0x02}) {
+			t.Error("expected sniffed application/octet-stream to be skipped")
+		}
+		if check("", nil) {
+			t.Error("expected empty body without Content-Type to be skipped")
+		}
+	})
+}
+
+func TestSniffEncodedContentType(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.SniffContentType = true
+
+	const html = "<!DOCTYPE html><html><body>hi</body></html>"
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(html))
+	zw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep net/http from sniffing a Content-Type of its own
+		w.Header()["Content-Type"] = nil
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	// The compressed bytes sniff as application/x-gzip; the decoded ones as
+	// text/html, which is cacheable
+	for _, want := range []string{"MISS", "HIT"} {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/page", nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Header().Get("X-Cache") != want {
+			t.Errorf("got X-Cache %q, want %s", w.Header().Get("X-Cache"), want)
+		}
+		if w.Body.String() != html {
+			t.Errorf("%s: got body %q, want the decoded page", want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers that give callers per-request control over caching. All
+// X-GoCache-* headers are removed before a request is forwarded upstream.
+const (
+	BypassHeader   = "X-GoCache-Bypass"    // Skip the cache entirely
+	RefreshHeader  = "X-GoCache-Refresh"   // Refetch and overwrite the cached entry
+	TTLHeader      = "X-GoCache-TTL"       // Override the TTL of the stored response
+	KeyExtraHeader = "X-GoCache-Key-Extra" // Partition the cache key
+	NoStoreHeader  = "X-GoCache-No-Store"  // Fetch on a miss without storing the response
+	DebugHeader    = "X-GoCache-Debug"     // Add the X-Cache-* debug response headers
+
+	controlHeaderPrefix = "X-Gocache-" // Canonical form of the X-GoCache- prefix
+)
+
+// requestControls holds the caching controls a caller sent with a request.
+type requestControls struct {
+	bypass   bool
+	refresh  bool
+	noStore  bool
+	debug    bool
+	ttl      time.Duration // Zero when not overridden
+	keyExtra string
+}
+
+type controlsContextKey struct{}
+
+// takeControls reads the X-GoCache-* control headers from r, strips every
+// X-GoCache-* header and returns r with the controls attached to its context.
+func (p *Proxy) takeControls(r *http.Request) *http.Request {
+	var ctl requestControls
+	ctl.bypass = headerFlag(r.Header, BypassHeader)
+	ctl.refresh = headerFlag(r.Header, RefreshHeader)
+	ctl.noStore = headerFlag(r.Header, NoStoreHeader)
+	ctl.debug = headerFlag(r.Header, DebugHeader)
+	ctl.keyExtra = r.Header.Get(KeyExtraHeader)
+	if v := r.Header.Get(TTLHeader); v != "" {
+		if ttl, err := time.ParseDuration(v); err == nil && ttl > 0 {
+			ctl.ttl = ttl
+		} else {
+			p.logger.Warn("ignoring invalid TTL header", "header", TTLHeader, "value", v)
+		}
+	}
+
+	for name := range r.Header {
+		if strings.HasPrefix(name, controlHeaderPrefix) {
+			r.Header.Del(name)
+		}
+	}
+	if ctl == (requestControls{}) {
+		return r
+	}
+	p.logger.Debug("request cache controls", "bypass", ctl.bypass, "refresh", ctl.refresh, "noStore", ctl.noStore, "debug", ctl.debug, "ttl", ctl.ttl, "keyExtra", ctl.keyExtra)
+	return r.WithContext(context.WithValue(r.Context(), controlsContextKey{}, ctl))
+}
+
+// controlsFrom returns the controls attached to r by takeControls.
+func controlsFrom(r *http.Request) requestControls {
+	ctl, _ := r.Context().Value(controlsContextKey{}).(requestControls)
+	return ctl
+}
+
+// headerFlag reports whether a boolean control header is set. A header that is
+// present but empty counts as set.
+func headerFlag(h http.Header, name string) bool {
+	values := h.Values(name)
+	if len(values) == 0 {
+		return false
+	}
+	if values[0] == "" {
+		return true
+	}
+	v, err := strconv.ParseBool(values[0])
+	return err == nil && v
+}
+
+// keyExtraPart returns the cache-key component for an X-GoCache-Key-Extra value.
+func keyExtraPart(extra string) string {
+	return "x=" + url.QueryEscape(extra)
+}
+
+// missStatus returns the cache status reported for a request that was not
+// served from the cache.
+func missStatus(r *http.Request) string {
+	if controlsFrom(r).bypass {
+		return "BYPASS"
+	}
+	return "MISS"
+}
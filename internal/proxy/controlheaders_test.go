@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestControlHeaders(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	var upstreamHits atomic.Int32
+	var leakedMu sync.Mutex
+	var leaked []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-gocache-") {
+				leakedMu.Lock()
+				leaked = append(leaked, name)
+				leakedMu.Unlock()
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "version %d", upstreamHits.Add(1))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	keyFor := func(path string, headers map[string]string) string {
+		h := http.Header{}
+		for k, v := range headers {
+			h.Set(k, v)
+		}
+		key, err := proxy.CacheKeyForRequest(server.URL+path, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	t.Run("bypass", func(t *testing.T) {
+		get("/bypass", nil)
+		w := get("/bypass", map[string]string{BypassHeader: "1"})
+		if w.Header().Get("X-Cache") != "BYPASS" || w.Body.String() != "version 2" {
+			t.Errorf("expected bypass to fetch from upstream, got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		if body := get("/bypass", nil).Body.String(); body != "version 1" {
+			t.Errorf("expected bypassed response not to overwrite the cache, got %q", body)
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		first := get("/refresh", nil).Body.String()
+		w := get("/refresh", map[string]string{RefreshHeader: "true"})
+		if w.Header().Get("X-Cache") != "MISS" || w.Body.String() == first {
+			t.Fatalf("expected refresh to refetch, got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		if body := get("/refresh", nil).Body.String(); body != w.Body.String() {
+			t.Errorf("expected refreshed response to overwrite the cache, got %q", body)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		get("/ttl", map[string]string{TTLHeader: "24h"})
+		entry, ok := proxy.cache.Peek(keyFor("/ttl", nil))
+		if !ok || entry.Expiry.Sub(entry.StoredAt) != 24*time.Hour {
+			t.Errorf("expected 24h TTL, got %v", entry.Expiry.Sub(entry.StoredAt))
+		}
+		get("/ttl-invalid", map[string]string{TTLHeader: "soon"})
+		entry, ok = proxy.cache.Peek(keyFor("/ttl-invalid", nil))
+		if !ok || entry.Expiry.Sub(entry.StoredAt) != time.Minute {
+			t.Errorf("expected invalid TTL header to be ignored, got %v", entry.Expiry.Sub(entry.StoredAt))
+		}
+	})
+
+	t.Run("key extra", func(t *testing.T) {
+		a := get("/extra", map[string]string{KeyExtraHeader: "tenant-a"}).Body.String()
+		b := get("/extra", map[string]string{KeyExtraHeader: "tenant-b"}).Body.String()
+		if a == b {
+			t.Error("expected key extra to partition the cache")
+		}
+		if body := get("/extra", map[string]string{KeyExtraHeader: "tenant-a"}).Body.String(); body != a {
+			t.Errorf("got %q, want cached %q", body, a)
+		}
+		if keyFor("/extra", map[string]string{KeyExtraHeader: "tenant-a"}) == keyFor("/extra", nil) {
+			t.Error("expected CacheKeyForRequest to include the key extra")
+		}
+	})
+
+	t.Run("no store", func(t *testing.T) {
+		get("/nostore", map[string]string{NoStoreHeader: ""})
+		if _, ok := proxy.cache.Peek(keyFor("/nostore", nil)); ok {
+			t.Error("expected response not to be stored")
+		}
+		get("/nostore", nil)
+		if w := get("/nostore", map[string]string{NoStoreHeader: "1"}); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected no-store request to be served from an existing entry")
+		}
+	})
+
+	t.Run("stripped over https", func(t *testing.T) {
+		tlsServer := httptest.NewTLSServer(handler)
+		defer tlsServer.Close()
+		client := newMITMClient(t, proxy)
+
+		req, _ := http.NewRequest(http.MethodGet, tlsServer.URL+"/secure", nil)
+		req.Header.Set(BypassHeader, "1")
+		req.Header.Set("X-GoCache-Unknown", "x")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("X-Cache") != "BYPASS" {
+			t.Errorf("got X-Cache %q, want BYPASS", resp.Header.Get("X-Cache"))
+		}
+	})
+
+	leakedMu.Lock()
+	defer leakedMu.Unlock()
+	if len(leaked) > 0 {
+		t.Errorf("control headers were forwarded upstream: %v", leaked)
+	}
+}
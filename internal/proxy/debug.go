@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// debugInfo describes how a response was handled by the cache.
+type debugInfo struct {
+	key      string
+	hit      bool             // Served from the cache
+	entry    cache.CacheEntry // Valid when hit or stored
+	stored   bool             // Stored in the cache on this request
+	reason   string           // Why the response was not cached
+	upstream time.Duration    // Time spent fetching from upstream
+}
+
+// debugEnabled reports whether the debug headers are enabled for r.
+func (p *Proxy) debugEnabled(r *http.Request) bool {
+	return p.config.Server.DebugHeaders || controlsFrom(r).debug
+}
+
+// setDebugHeaders adds the X-Cache-* debug headers to h if they are enabled
+// for r.
+func (p *Proxy) setDebugHeaders(h http.Header, r *http.Request, info debugInfo) {
+	if !p.debugEnabled(r) {
+		return
+	}
+	if info.key != "" {
+		h.Set("X-Cache-Key", info.key)
+	}
+	if rule := p.ruleFor(r); rule != nil {
+		h.Set("X-Cache-Rule", rule.Name)
+	}
+	if info.hit || info.stored {
+		now := time.Now()
+		if info.hit {
+			h.Set("Age", strconv.FormatInt(int64(now.Sub(info.entry.StoredAt)/time.Second), 10))
+		}
+		h.Set("X-Cache-Stored-At", info.entry.StoredAt.UTC().Format(http.TimeFormat))
+		if info.entry.Expiry.IsZero() {
+			h.Set("X-Cache-TTL-Remaining", "never")
+		} else {
+			remaining := max(info.entry.Expiry.Sub(now), 0)
+			h.Set("X-Cache-TTL-Remaining", strconv.FormatInt(int64(remaining/time.Second), 10))
+		}
+	}
+	if info.reason != "" {
+		h.Set("X-Cache-Reason", info.reason)
+	}
+	if info.upstream > 0 {
+		h.Set("X-Cache-Upstream-Time", strconv.FormatInt(info.upstream.Milliseconds(), 10))
+	}
+}
+
+// storedDebugInfo completes info for a response that was meant to be stored
+// under key in c by a fetch that started at since. The cache rejects entries
+// larger than its size budget, which is reported as "too-large".
+func storedDebugInfo(c *cache.MemoryCache, key string, since time.Time, info debugInfo) debugInfo {
+	if info.reason != "" {
+		return info
+	}
+	if entry, ok := c.Peek(key); ok && !entry.StoredAt.Before(since) {
+		info.entry = entry
+		info.stored = true
+	} else {
+		info.reason = "too-large"
+	}
+	return info
+}
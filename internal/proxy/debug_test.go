@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+func TestDebugHeaders(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/binary") {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		time.Sleep(5 * time.Millisecond)
+		if r.URL.Path == "/big" {
+			w.Write([]byte(strings.Repeat("x", 2*1024*1024)))
+			return
+		}
+		w.Write([]byte("body"))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		if w := get("/plain", nil); w.Header().Get("X-Cache-Key") != "" {
+			t.Error("expected no debug headers unless enabled")
+		}
+	})
+
+	t.Run("enabled per request", func(t *testing.T) {
+		w := get("/per-request", map[string]string{DebugHeader: "1"})
+		if w.Header().Get("X-Cache-Key") == "" {
+			t.Error("expected debug headers when requested")
+		}
+		if w := get("/per-request", nil); w.Header().Get("X-Cache-Key") != "" {
+			t.Error("expected debug headers not to be stored with the entry")
+		}
+	})
+
+	proxy.config.Server.DebugHeaders = true
+	proxy.config.Cache.Rules = []config.CacheRule{{Name: "docs", PathPrefix: "/docs"}}
+
+	t.Run("miss", func(t *testing.T) {
+		w := get("/docs/a", nil)
+		h := w.Header()
+		if h.Get("X-Cache-Key") != proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+"/docs/a", nil)) {
+			t.Errorf("unexpected X-Cache-Key %q", h.Get("X-Cache-Key"))
+		}
+		if h.Get("X-Cache-Rule") != "docs" {
+			t.Errorf("got X-Cache-Rule %q, want docs", h.Get("X-Cache-Rule"))
+		}
+		if ms, err := strconv.Atoi(h.Get("X-Cache-Upstream-Time")); err != nil || ms < 5 {
+			t.Errorf("unexpected X-Cache-Upstream-Time %q", h.Get("X-Cache-Upstream-Time"))
+		}
+		if h.Get("X-Cache-TTL-Remaining") != "60" && h.Get("X-Cache-TTL-Remaining") != "59" {
+			t.Errorf("unexpected X-Cache-TTL-Remaining %q", h.Get("X-Cache-TTL-Remaining"))
+		}
+		if _, err := http.ParseTime(h.Get("X-Cache-Stored-At")); err != nil {
+			t.Errorf("unexpected X-Cache-Stored-At %q", h.Get("X-Cache-Stored-At"))
+		}
+		if h.Get("Age") != "" || h.Get("X-Cache-Reason") != "" {
+			t.Errorf("unexpected Age %q or X-Cache-Reason %q on a stored miss", h.Get("Age"), h.Get("X-Cache-Reason"))
+		}
+	})
+
+	t.Run("hit", func(t *testing.T) {
+		time.Sleep(1100 * time.Millisecond)
+		h := get("/docs/a", nil).Header()
+		if h.Get("X-Cache") != "HIT" {
+			t.Fatal("expected a cache hit")
+		}
+		if h.Get("Age") != "1" {
+			t.Errorf("got Age %q, want 1", h.Get("Age"))
+		}
+		if h.Get("X-Cache-Upstream-Time") != "" {
+			t.Error("expected no upstream time on a hit")
+		}
+	})
+
+	reasons := []struct {
+		path    string
+		method  string
+		headers map[string]string
+		want    string
+	}{
+		{"/binary", http.MethodGet, nil, "content-type"},
+		{"/nostore", http.MethodGet, map[string]string{NoStoreHeader: "1"}, "request-header"},
+		{"/put", http.MethodPut, nil, "method"},
+	}
+	for _, tt := range reasons {
+		t.Run("reason "+tt.want, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, server.URL+tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if got := w.Header().Get("X-Cache-Reason"); got != tt.want {
+				t.Errorf("got X-Cache-Reason %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("too large", func(t *testing.T) {
+		small, cleanup := setupTestProxy(t)
+		defer cleanup()
+		small.cache.Shutdown()
+		small.cache = cache.NewMemoryCache(time.Minute, 1)
+		small.namespaces = nil
+		small.config.Server.DebugHeaders = true
+
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/big", nil)
+		w := httptest.NewRecorder()
+		small.ServeHTTP(w, req)
+		if got := w.Header().Get("X-Cache-Reason"); got != "too-large" {
+			t.Errorf("got X-Cache-Reason %q, want too-large", got)
+		}
+	})
+
+	t.Run("https", func(t *testing.T) {
+		tlsServer := httptest.NewTLSServer(handler)
+		defer tlsServer.Close()
+		client := newMITMClient(t, proxy)
+
+		for i, want := range []string{"MISS", "HIT"} {
+			resp, err := client.Get(tlsServer.URL + "/docs/secure")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.Header.Get("X-Cache") != want || resp.Header.Get("X-Cache-Key") == "" || resp.Header.Get("X-Cache-Rule") != "docs" {
+				t.Errorf("request %d: unexpected headers %v", i, resp.Header)
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// requestDirectives holds the RFC 9111 cache directives sent by a client.
+// Durations are negative when the directive is absent.
+type requestDirectives struct {
+	noCache      bool
+	noStore      bool
+	onlyIfCached bool
+	maxAge       time.Duration
+	maxStale     time.Duration // Zero or more; anyStale is set for a bare max-stale
+	anyStale     bool
+	minFresh     time.Duration
+}
+
+// parseRequestDirectives reads the Cache-Control request header. Pragma:
+// no-cache is honored only when Cache-Control is absent.
+func parseRequestDirectives(h http.Header) requestDirectives {
+	d := requestDirectives{maxAge: -1, maxStale: -1, minFresh: -1}
+
+	values := h.Values("Cache-Control")
+	if len(values) == 0 {
+		for _, v := range h.Values("Pragma") {
+			if strings.EqualFold(strings.TrimSpace(v), "no-cache") {
+				d.noCache = true
+			}
+		}
+		return d
+	}
+
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			name, arg, hasArg := strings.Cut(strings.TrimSpace(directive), "=")
+			arg = strings.Trim(arg, `"`)
+			switch strings.ToLower(name) {
+			case "no-cache":
+				d.noCache = true
+			case "no-store":
+				d.noStore = true
+			case "only-if-cached":
+				d.onlyIfCached = true
+			case "max-age":
+				d.maxAge = parseDeltaSeconds(arg)
+			case "max-stale":
+				if !hasArg {
+					d.anyStale = true
+				} else {
+					d.maxStale = parseDeltaSeconds(arg)
+				}
+			case "min-fresh":
+				d.minFresh = parseDeltaSeconds(arg)
+			}
+		}
+	}
+	return d
+}
+
+// parseDeltaSeconds parses a delta-seconds value, returning -1 if it is invalid.
+func parseDeltaSeconds(s string) time.Duration {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return time.Duration(n) * time.Second
+}
+
+// clientDirectives returns the cache directives of r, or nil when the proxy
+// is not configured to honor them.
+func (p *Proxy) clientDirectives(r *http.Request) *requestDirectives {
+	if !p.config.Cache.HonorClientDirectives {
+		return nil
+	}
+	d := parseRequestDirectives(r.Header)
+	return &d
+}
+
+// lookupEntry returns the cached response for key unless r asks to bypass or
+// refresh the cache, and only if it satisfies the cache directives of r:
+// no-cache and max-age=0 force a fetch from upstream, max-age and min-fresh
+// reject entries that are too old, and max-stale accepts recently expired
+// entries.
+func (p *Proxy) lookupEntry(c *cache.MemoryCache, r *http.Request, key string) (cache.CacheEntry, bool) {
+	if ctl := controlsFrom(r); ctl.bypass || ctl.refresh {
+		p.logger.Debug("skipping cache lookup: requested by header", "key", key, "bypass", ctl.bypass, "refresh", ctl.refresh)
+		return cache.CacheEntry{}, false
+	}
+	d := p.clientDirectives(r)
+	if d == nil {
+		return c.Get(key)
+	}
+	if d.noCache || d.maxAge == 0 {
+		p.logger.Debug("bypassing cache: client requested revalidation", "key", key)
+		return cache.CacheEntry{}, false
+	}
+
+	maxStale := d.maxStale
+	if d.anyStale {
+		maxStale = -1
+	} else if maxStale < 0 {
+		maxStale = 0
+	}
+	return c.GetAcceptable(key, maxStale, func(entry cache.CacheEntry) bool {
+		now := time.Now()
+		if d.maxAge > 0 && now.Sub(entry.StoredAt) > d.maxAge {
+			return false
+		}
+		if d.minFresh > 0 && !entry.Expiry.IsZero() && entry.Expiry.Sub(now) < d.minFresh {
+			return false
+		}
+		return true
+	})
+}
+
+// onlyIfCached reports whether r must not be forwarded upstream on a miss.
+func (p *Proxy) onlyIfCached(r *http.Request) bool {
+	d := p.clientDirectives(r)
+	return d != nil && d.onlyIfCached
+}
+
+// clientNoStore reports whether r asked for its response not to be stored.
+func (p *Proxy) clientNoStore(r *http.Request) bool {
+	d := p.clientDirectives(r)
+	return d != nil && d.noStore
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestParseRequestDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   requestDirectives
+	}{
+		{"none", http.Header{}, requestDirectives{maxAge: -1, maxStale: -1, minFresh: -1}},
+		{"no-cache", http.Header{"Cache-Control": {"No-Cache"}}, requestDirectives{noCache: true, maxAge: -1, maxStale: -1, minFresh: -1}},
+		{"pragma", http.Header{"Pragma": {"no-cache"}}, requestDirectives{noCache: true, maxAge: -1, maxStale: -1, minFresh: -1}},
+		{"pragma ignored with cache-control", http.Header{"Pragma": {"no-cache"}, "Cache-Control": {"max-age=5"}}, requestDirectives{maxAge: 5 * time.Second, maxStale: -1, minFresh: -1}},
+		{"several", http.Header{"Cache-Control": {"max-age=60, min-fresh=\"10\"", "no-store"}}, requestDirectives{noStore: true, maxAge: time.Minute, maxStale: -1, minFresh: 10 * time.Second}},
+		{"bare max-stale", http.Header{"Cache-Control": {"max-stale"}}, requestDirectives{anyStale: true, maxAge: -1, maxStale: -1, minFresh: -1}},
+		{"max-stale value", http.Header{"Cache-Control": {"max-stale=30, only-if-cached"}}, requestDirectives{onlyIfCached: true, maxAge: -1, maxStale: 30 * time.Second, minFresh: -1}},
+		{"invalid value", http.Header{"Cache-Control": {"max-age=soon"}}, requestDirectives{maxAge: -1, maxStale: -1, minFresh: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRequestDirectives(tt.header); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientDirectives(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	var upstreamHits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "version %d", upstreamHits.Add(1))
+	}))
+	defer server.Close()
+
+	get := func(path, cacheControl string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("ignored by default", func(t *testing.T) {
+		get("/default", "")
+		if w := get("/default", "no-cache"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected no-cache to be ignored when honor_client_directives is off")
+		}
+		if w := get("/uncached", "only-if-cached"); w.Code != http.StatusOK {
+			t.Errorf("got status %d, want 200", w.Code)
+		}
+	})
+
+	proxy.config.Cache.HonorClientDirectives = true
+
+	t.Run("no-cache refetches and stores", func(t *testing.T) {
+		get("/a", "")
+		w := get("/a", "no-cache")
+		if w.Header().Get("X-Cache") != "MISS" {
+			t.Fatal("expected no-cache to bypass the cache")
+		}
+		if body := get("/a", "").Body.String(); body != w.Body.String() {
+			t.Errorf("expected revalidated response to be cached, got %q want %q", body, w.Body.String())
+		}
+	})
+
+	t.Run("max-age=0", func(t *testing.T) {
+		get("/b", "")
+		if w := get("/b", "max-age=0"); w.Header().Get("X-Cache") != "MISS" {
+			t.Error("expected max-age=0 to bypass the cache")
+		}
+	})
+
+	t.Run("max-age and min-fresh", func(t *testing.T) {
+		key := proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+"/c", nil))
+		proxy.cache.SetWithTTL(key, cache.CacheEntry{
+			StatusCode: http.StatusOK,
+			Headers:    http.Header{"Content-Type": {"text/plain"}},
+			Body:       []byte("old"),
+		}, 30*time.Second)
+		time.Sleep(1100 * time.Millisecond)
+
+		if w := get("/c", "max-age=60"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected entry younger than max-age to be served")
+		}
+		if w := get("/c", "min-fresh=10"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected entry fresh for more than min-fresh to be served")
+		}
+		if w := get("/c", "min-fresh=3600"); w.Header().Get("X-Cache") != "MISS" {
+			t.Error("expected entry expiring within min-fresh to be refetched")
+		}
+		proxy.cache.SetWithTTL(key, cache.CacheEntry{StatusCode: http.StatusOK, Body: []byte("old")}, time.Hour)
+		time.Sleep(1100 * time.Millisecond)
+		if w := get("/c", "max-age=1"); w.Header().Get("X-Cache") != "MISS" {
+			t.Error("expected entry older than max-age to be refetched")
+		}
+	})
+
+	t.Run("max-stale", func(t *testing.T) {
+		key := proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+"/d", nil))
+		store := func() {
+			proxy.cache.SetWithTTL(key, cache.CacheEntry{StatusCode: http.StatusOK, Body: []byte("stale")}, time.Millisecond)
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		store()
+		if w := get("/d", "max-stale=60"); w.Body.String() != "stale" {
+			t.Error("expected stale entry within max-stale to be served")
+		}
+		store()
+		if w := get("/d", "max-stale"); w.Body.String() != "stale" {
+			t.Error("expected stale entry to be served for a bare max-stale")
+		}
+		store()
+		if w := get("/d", ""); w.Body.String() == "stale" {
+			t.Error("expected stale entry not to be served without max-stale")
+		}
+	})
+
+	t.Run("only-if-cached", func(t *testing.T) {
+		before := upstreamHits.Load()
+		if w := get("/never-fetched", "only-if-cached"); w.Code != http.StatusGatewayTimeout {
+			t.Errorf("got status %d, want 504", w.Code)
+		}
+		if upstreamHits.Load() != before {
+			t.Error("expected only-if-cached miss not to contact upstream")
+		}
+		get("/e", "")
+		if w := get("/e", "only-if-cached"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected only-if-cached hit to be served")
+		}
+	})
+
+	t.Run("no-store", func(t *testing.T) {
+		get("/f", "no-store")
+		if w := get("/f", ""); w.Header().Get("X-Cache") != "MISS" {
+			t.Error("expected response to a no-store request not to be cached")
+		}
+	})
+}
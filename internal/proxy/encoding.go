@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// upstreamAcceptEncoding replaces the client's Accept-Encoding on requests
+// whose response may be stored. The cache keeps the identity body of each
+// response and encodes it to suit each client, so it only asks for codings
+// it can decode.
+const upstreamAcceptEncoding = "gzip, deflate"
+
+// servedCodings are the content codings the proxy can produce, in order of
+// preference.
+var servedCodings = []string{"gzip", "deflate"}
+
+// takeAcceptEncoding replaces the Accept-Encoding header of r with
+// upstreamAcceptEncoding if its response may be stored, and returns the
+// client's header so it can be restored with restoreHeaders. It returns nil
+// if the header was left alone.
+func (p *Proxy) takeAcceptEncoding(r *http.Request) http.Header {
+	cacheable := p.requestNotCacheable(r) == "" || p.bodyCacheable(r)
+	if !cacheable || !p.mayStore(r) {
+		return nil
+	}
+	saved := http.Header{"Accept-Encoding": r.Header.Values("Accept-Encoding")}
+	r.Header.Set("Accept-Encoding", upstreamAcceptEncoding)
+	return saved
+}
+
+// contentCoding returns the content coding of a response with header h, or
+// "" for the identity coding.
+func contentCoding(h http.Header) string {
+	coding := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding")))
+	switch coding {
+	case "identity":
+		return ""
+	case "x-gzip":
+		return "gzip"
+	}
+	return coding
+}
+
+// canDecode reports whether the proxy can decode a content coding.
+func canDecode(coding string) bool {
+	return coding == "" || coding == "gzip" || coding == "deflate"
+}
+
+// codingQuality returns the quality the Accept-Encoding header in h gives a
+// content coding ("" for identity), from 0 (not acceptable) to 1. A client
+// that sends no Accept-Encoding gets the identity coding only.
+func codingQuality(h http.Header, coding string) float64 {
+	if coding == "" {
+		coding = "identity"
+	}
+	values := h.Values("Accept-Encoding")
+	if len(values) == 0 {
+		if coding == "identity" {
+			return 1
+		}
+		return 0
+	}
+
+	quality, wildcard := -1.0, -1.0
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "x-gzip" {
+				name = "gzip"
+			}
+			q := 1.0
+			if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+			switch name {
+			case coding:
+				quality = q
+			case "*":
+				wildcard = q
+			}
+		}
+	}
+	switch {
+	case quality >= 0:
+		return quality
+	case wildcard >= 0:
+		return wildcard
+	case coding == "identity":
+		// Identity is acceptable unless explicitly excluded
+		return 1
+	}
+	return 0
+}
+
+// acceptsCoding reports whether the client of r accepts a content coding.
+func acceptsCoding(r *http.Request, coding string) bool {
+	return codingQuality(r.Header, coding) > 0
+}
+
+// negotiateCoding picks the content coding for a body sent to the client of
+// r: the coding it rates highest, preferring codings in held on a tie, and
+// identity if it accepts no coding the proxy can produce.
+func negotiateCoding(r *http.Request, held map[string][]byte) string {
+	best, bestQ := "", 0.0
+	for _, coding := range servedCodings {
+		q := codingQuality(r.Header, coding)
+		_, isHeld := held[coding]
+		_, bestHeld := held[best]
+		if q > bestQ || q == bestQ && q > 0 && isHeld && !bestHeld {
+			best, bestQ = coding, q
+		}
+	}
+	if best != "" && codingQuality(r.Header, "") > bestQ {
+		return ""
+	}
+	return best
+}
+
+// newDecoder returns a reader that decodes a body in a content coding the
+// proxy can decode. Deflate bodies are accepted in both the zlib format the
+// standard requires and the raw format some servers send.
+func newDecoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case "":
+		return r, nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("unsupported content coding %q", coding)
+}
+
+// isZlibHeader reports whether b starts with a zlib stream header.
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// decodeBody decodes a complete body.
+func decodeBody(coding string, data []byte) ([]byte, error) {
+	r, err := newDecoder(coding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// encodeBody encodes a complete body in a content coding the proxy can produce.
+func encodeBody(coding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content coding %q", coding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalEntry returns entry with its body in the identity coding. An
+// encoded body is decoded and kept in Encodings, so clients that accept its
+// coding are served the upstream bytes.
+func canonicalEntry(entry cache.CacheEntry) (cache.CacheEntry, error) {
+	coding := contentCoding(entry.Headers)
+	entry.Headers = entry.Headers.Clone()
+	entry.Headers.Del("Content-Encoding")
+	entry.Headers.Del("Content-Length")
+	if coding == "" {
+		return entry, nil
+	}
+	body, err := decodeBody(coding, entry.Body)
+	if err != nil {
+		return entry, err
+	}
+	entry.Encodings = map[string][]byte{coding: entry.Body}
+	entry.Body = body
+	return entry, nil
+}
+
+// negotiable reports whether entry holds an identity body that can be encoded
+// for each client. Entries stored with a Content-Encoding, such as those
+// cached by older versions, are served as they are.
+func negotiable(entry cache.CacheEntry) bool {
+	return contentCoding(entry.Headers) == "" && len(entry.Body) > 0 &&
+		entry.StatusCode != http.StatusNoContent && entry.StatusCode != http.StatusNotModified
+}
+
+// selectRepresentation returns the content coding and body of entry to send
+// to the client of r, encoding the body if entry does not hold it in that
+// coding yet. encoded reports whether a new encoding was produced.
+func selectRepresentation(r *http.Request, entry cache.CacheEntry) (coding string, body []byte, encoded bool) {
+	if !negotiable(entry) {
+		return contentCoding(entry.Headers), entry.Body, false
+	}
+	coding = negotiateCoding(r, entry.Encodings)
+	if coding == "" {
+		return "", entry.Body, false
+	}
+	if data, ok := entry.Encodings[coding]; ok {
+		return coding, data, false
+	}
+	data, err := encodeBody(coding, entry.Body)
+	if err != nil {
+		return "", entry.Body, false
+	}
+	return coding, data, true
+}
+
+// setCodingHeaders describes a body of n bytes in a content coding in h.
+func setCodingHeaders(h http.Header, coding string, n int) {
+	if coding == "" {
+		h.Del("Content-Encoding")
+	} else {
+		h.Set("Content-Encoding", coding)
+	}
+	h.Set("Content-Length", strconv.Itoa(n))
+	addVary(h, "Accept-Encoding")
+}
+
+// addVary adds a header name to the Vary header in h unless it is listed.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// writeEntry writes entry to w in the content coding the client of r
+// prefers, with X-Cache set to status unless it is empty, or a 304 if the
+// client's copy is current. If c is not nil, a newly produced encoding is
+// added to the entry stored under key in c.
+func (p *Proxy) writeEntry(w http.ResponseWriter, r *http.Request, c *cache.MemoryCache, key string, entry cache.CacheEntry, status string, info debugInfo) {
+	h := w.Header()
+	copyHeaders(h, entry.Headers)
+	if status != "" {
+		h.Set("X-Cache", status)
+	}
+	if notModified(r, entry) {
+		if negotiable(entry) {
+			addVary(h, "Accept-Encoding")
+		}
+		p.setDebugHeaders(h, r, info)
+		writeNotModified(w)
+		return
+	}
+
+	coding, body, encoded := selectRepresentation(r, entry)
+	if encoded && c != nil {
+		c.AddEncoding(key, coding, body, entry.StoredAt)
+	}
+	if negotiable(entry) {
+		setCodingHeaders(h, coding, len(body))
+	}
+	p.setDebugHeaders(h, r, info)
+	writeBody(w, r, entry.StatusCode, body)
+}
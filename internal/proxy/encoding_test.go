@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNegotiateCoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		held           []string
+		want           string
+	}{
+		{"", nil, ""},
+		{"gzip", nil, "gzip"},
+		{"gzip, deflate", nil, "gzip"},
+		{"gzip, deflate", []string{"deflate"}, "deflate"},
+		{"deflate;q=1, gzip;q=0.5", nil, "deflate"},
+		{"gzip;q=0", nil, ""},
+		{"br", nil, ""},
+		{"*", nil, "gzip"},
+		{"identity;q=1, gzip;q=0.5", nil, ""},
+		{"X-GZIP", nil, "gzip"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		if tt.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		held := map[string][]byte{}
+		for _, coding := range tt.held {
+			held[coding] = nil
+		}
+		if got := negotiateCoding(r, held); got != tt.want {
+			t.Errorf("negotiateCoding(%q, %v) = %q, want %q", tt.acceptEncoding, tt.held, got, tt.want)
+		}
+	}
+}
+
+func TestCodingQuality(t *testing.T) {
+	h := http.Header{"Accept-Encoding": {"gzip;q=0.8, *;q=0"}}
+	if q := codingQuality(h, "gzip"); q != 0.8 {
+		t.Errorf("got gzip quality %v, want 0.8", q)
+	}
+	if q := codingQuality(h, ""); q != 0 {
+		t.Errorf("got identity quality %v, want 0 when excluded by *", q)
+	}
+	if q := codingQuality(http.Header{"Accept-Encoding": {"gzip"}}, ""); q != 1 {
+		t.Errorf("got identity quality %v, want 1 unless excluded", q)
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat("compressible text ", 100))
+	for _, coding := range servedCodings {
+		encoded, err := encodeBody(coding, body)
+		if err != nil {
+			t.Fatalf("%s: encode failed: %v", coding, err)
+		}
+		decoded, err := decodeBody(coding, encoded)
+		if err != nil || !bytes.Equal(decoded, body) {
+			t.Errorf("%s: round trip failed: %v", coding, err)
+		}
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestAcceptEncodingServing(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	body := []byte(strings.Repeat("hello gzip ", 50))
+	var mu sync.Mutex
+	var upstreamEncodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamEncodings = append(upstreamEncodings, r.Header.Get("Accept-Encoding"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			data := gzipBytes(t, body)
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data)
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	decoded := func(t *testing.T, w *httptest.ResponseRecorder) []byte {
+		t.Helper()
+		coding := w.Header().Get("Content-Encoding")
+		if n, _ := strconv.Atoi(w.Header().Get("Content-Length")); w.Header().Get("Content-Length") != "" && n != w.Body.Len() {
+			t.Errorf("Content-Length %d does not match body length %d", n, w.Body.Len())
+		}
+		if coding == "" {
+			return w.Body.Bytes()
+		}
+		data, err := decodeBody(coding, w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("failed to decode %s body: %v", coding, err)
+		}
+		return data
+	}
+
+	t.Run("identity client on miss", func(t *testing.T) {
+		w := get("/page", "")
+		if w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("expected identity response, got Content-Encoding %q", w.Header().Get("Content-Encoding"))
+		}
+		if !bytes.Equal(w.Body.Bytes(), body) {
+			t.Errorf("expected decoded body, got %q", w.Body.String())
+		}
+		if upstreamEncodings[0] != upstreamAcceptEncoding {
+			t.Errorf("got upstream Accept-Encoding %q, want %q", upstreamEncodings[0], upstreamAcceptEncoding)
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+			t.Error("expected Vary: Accept-Encoding")
+		}
+	})
+
+	t.Run("stored canonical", func(t *testing.T) {
+		entry, ok := proxy.cache.Peek(proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+"/page", nil)))
+		if !ok {
+			t.Fatal("expected response to be cached")
+		}
+		if !bytes.Equal(entry.Body, body) || entry.Headers.Get("Content-Encoding") != "" {
+			t.Error("expected identity body to be stored")
+		}
+	})
+
+	for _, tt := range []struct{ acceptEncoding, want string }{
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"", ""},
+		{"br", ""},
+	} {
+		t.Run("hit for "+tt.acceptEncoding, func(t *testing.T) {
+			w := get("/page", tt.acceptEncoding)
+			if w.Header().Get("X-Cache") != "HIT" {
+				t.Fatalf("got X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.want {
+				t.Errorf("got Content-Encoding %q, want %q", got, tt.want)
+			}
+			if !bytes.Equal(decoded(t, w), body) {
+				t.Error("body does not match the upstream body")
+			}
+		})
+	}
+
+	t.Run("encodings tracked", func(t *testing.T) {
+		entry, _ := proxy.cache.Peek(proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+"/page", nil)))
+		if len(entry.Encodings) != 2 {
+			t.Errorf("expected gzip and deflate to be held, got %d encodings", len(entry.Encodings))
+		}
+	})
+
+	t.Run("gzip client on miss", func(t *testing.T) {
+		w := get("/other", "gzip")
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("got Content-Encoding %q, want gzip", w.Header().Get("Content-Encoding"))
+		}
+		if !bytes.Equal(decoded(t, w), body) {
+			t.Error("body does not match the upstream body")
+		}
+		entry, _ := proxy.cache.Peek(proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+"/other", nil)))
+		if !bytes.Equal(entry.Body, body) {
+			t.Error("expected identity body to be stored")
+		}
+		if !bytes.Equal(entry.Encodings["gzip"], w.Body.Bytes()) {
+			t.Error("expected the upstream gzip body to be held")
+		}
+	})
+
+	t.Run("range on encoded representation", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/page", nil)
+		req.Header.Set("Range", "bytes=0-4")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusPartialContent || w.Body.String() != string(body[:5]) {
+			t.Errorf("got %d %q, want 206 from the identity body", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestAcceptEncodingHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	body := []byte(strings.Repeat("hello https ", 50))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipBytes(t, body))
+	}))
+	defer server.Close()
+
+	// An explicit Accept-Encoding stops the client transport from decoding
+	// gzip itself, so the body shows what the proxy sent.
+	client := newMITMClient(t, proxy)
+	for _, want := range []string{"MISS", "HIT"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+		req.Header.Set("Accept-Encoding", "identity")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("X-Cache") != want {
+			t.Errorf("got X-Cache %q, want %s", resp.Header.Get("X-Cache"), want)
+		}
+		if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(buf.Bytes(), body) {
+			t.Errorf("%s: expected identity body, got Content-Encoding %q", want, resp.Header.Get("Content-Encoding"))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// FetchResult describes the outcome of a request made with Fetch.
+type FetchResult struct {
+	StatusCode  int
+	CacheStatus string // "HIT", "MISS", or "" when the response was not cacheable
+}
+
+// fetchResponseWriter is a minimal http.ResponseWriter that captures the status
+// and headers of an internally generated request and forwards the body.
+type fetchResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       io.Writer
+}
+
+func (w *fetchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fetchResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *fetchResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// Fetch issues a GET for rawURL through the proxy's normal cache lookup and
+// store path, as if a client had requested it. An empty namespace uses the
+// default cache. The response body is written to body, which may be nil to
+// discard it. A response whose body is cut short upstream is returned as an
+// error, after the bytes received are written to body.
+func (p *Proxy) Fetch(ctx context.Context, namespace, rawURL string, body io.Writer) (FetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	if namespace != "" {
+		req.Header.Set(NamespaceHeader, namespace)
+	}
+	if body == nil {
+		body = io.Discard
+	}
+
+	w := &fetchResponseWriter{header: make(http.Header), body: body}
+	err = p.handleRequest(w, req, req)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return FetchResult{}, ctxErr
+	}
+	if err != nil {
+		return FetchResult{}, err
+	}
+
+	return FetchResult{
+		StatusCode:  w.statusCode,
+		CacheStatus: w.header.Get("X-Cache"),
+	}, nil
+}
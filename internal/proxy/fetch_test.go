@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>warm</p>"))
+	}))
+	defer server.Close()
+
+	var body bytes.Buffer
+	result, err := proxy.Fetch(context.Background(), "", server.URL+"/page", &body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.StatusCode != http.StatusOK || result.CacheStatus != "MISS" {
+		t.Errorf("got %+v, want 200 MISS", result)
+	}
+	if body.String() != "<p>warm</p>" {
+		t.Errorf("got body %q", body.String())
+	}
+
+	// The response must now be in the cache under the normal key.
+	if _, ok := proxy.cache.Get(server.URL + "/page"); !ok {
+		t.Error("expected fetched response to be cached")
+	}
+
+	result, err = proxy.Fetch(context.Background(), "", server.URL+"/page", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.CacheStatus != "HIT" {
+		t.Errorf("got cache status %q, want HIT", result.CacheStatus)
+	}
+
+	result, err = proxy.Fetch(context.Background(), "", server.URL+"/missing", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", result.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := proxy.Fetch(ctx, "", server.URL+"/other", nil); err == nil {
+		t.Error("expected error for cancelled context")
+	}
+	if _, err := proxy.Fetch(context.Background(), "", "://bad", nil); err == nil {
+		t.Error("expected error for invalid URL")
+	}
+}
+
+func TestFetchTruncated(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	upstream := streamingUpstream(t, false, nil)
+	defer upstream.Close()
+
+	// A body cut short upstream is reported as a failed fetch rather than
+	// aborting the caller
+	var body bytes.Buffer
+	if _, err := proxy.Fetch(context.Background(), "", upstream.URL+"/truncated", &body); err == nil {
+		t.Error("expected error for a truncated upstream body")
+	}
+	if body.String() != "partial" {
+		t.Errorf("got body %q, want the bytes received before the truncation", body.String())
+	}
+	if _, ok := proxy.cache.Peek(upstream.URL + "/truncated"); ok {
+		t.Error("expected truncated body not to be cached")
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// serveH2 serves the HTTP/2 streams of the decrypted connection of the
+// CONNECT request r until the client closes it or it has been idle for
+// idleTimeout. Streams are handled concurrently, each through the same cache
+// lookup and store logic as plain HTTP requests.
+func (p *Proxy) serveH2(tlsConn *tls.Conn, r *http.Request, idleTimeout time.Duration) {
+	var served atomic.Uint64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p.mitmRequests.Add(1)
+		p.recordMaxRequests(served.Add(1))
+
+		req.URL.Scheme = "https"
+		req.URL.Host = r.Host
+		if err := p.handleRequest(&h2ResponseWriter{ResponseWriter: w}, req, r); err != nil {
+			// Reset the stream so the client sees the truncation
+			panic(http.ErrAbortHandler)
+		}
+	})
+
+	server := &http2.Server{IdleTimeout: idleTimeout}
+	server.ServeConn(tlsConn, &http2.ServeConnOpts{
+		Context: r.Context(),
+		Handler: handler,
+	})
+	p.logger.Debug("http/2 connection closed", "host", r.Host, "requests", served.Load())
+}
+
+// h2ResponseWriter removes the connection-specific headers that HTTP/2
+// forbids (RFC 9113 section 8.2.2) from responses copied from upstream or
+// the cache.
+type h2ResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *h2ResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		removeHopHeaders(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *h2ResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the stream's writer.
+func (w *h2ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
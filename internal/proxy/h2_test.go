@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newH2Client is like newMITMClient, but the client offers HTTP/2 on the
+// intercepted connection.
+func newH2Client(t *testing.T, p *Proxy) *http.Client {
+	t.Helper()
+	proxyServer := httptest.NewServer(p)
+	t.Cleanup(proxyServer.Close)
+
+	p.SetTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})
+
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(p.GetCA())
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			ForceAttemptHTTP2: true,
+		},
+	}
+}
+
+func TestHTTP2MITM(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.PostCache.Enable = true
+
+	var mu sync.Mutex
+	upstreamRequests := map[string]int{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamRequests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer server.Close()
+
+	client := newH2Client(t, proxy)
+	get := func(path string) (*http.Response, string, error) {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, string(body), err
+	}
+
+	resp, body, err := get("/warm")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.ProtoMajor != 2 || body != "/warm " || resp.Header.Get("X-Cache") != "MISS" {
+		t.Fatalf("got %s, X-Cache %q and %q, want an HTTP/2 MISS", resp.Proto, resp.Header.Get("X-Cache"), body)
+	}
+
+	t.Run("multiplexed streams", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var failures atomic.Int32
+		for i := range 30 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				path := fmt.Sprintf("/item/%d", i%3)
+				resp, body, err := get(path)
+				if err != nil || resp.ProtoMajor != 2 || body != path+" " {
+					failures.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := failures.Load(); n > 0 {
+			t.Fatalf("%d concurrent requests failed or got the wrong body", n)
+		}
+
+		for i := range 3 {
+			path := fmt.Sprintf("/item/%d", i)
+			resp, body, err := get(path)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if resp.Header.Get("X-Cache") != "HIT" || body != path+" " {
+				t.Errorf("%s: got X-Cache %q and %q, want a HIT with its own body", path, resp.Header.Get("X-Cache"), body)
+			}
+			if resp.Header.Get("Keep-Alive") != "" {
+				t.Errorf("%s: expected the Keep-Alive header to be removed", path)
+			}
+		}
+	})
+
+	t.Run("POST", func(t *testing.T) {
+		for _, want := range []string{"MISS", "HIT"} {
+			resp, err := client.Post(server.URL+"/api", "text/plain", strings.NewReader("query"))
+			if err != nil {
+				t.Fatalf("POST failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.Header.Get("X-Cache") != want || string(body) != "/api query" {
+				t.Errorf("got X-Cache %q and %q, want %s", resp.Header.Get("X-Cache"), body, want)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if n := upstreamRequests["POST /api"]; n != 1 {
+			t.Errorf("got %d upstream POSTs, want 1", n)
+		}
+	})
+
+	metrics := proxy.GetMITMConnectionMetrics()
+	if metrics.Connections != 1 || metrics.Requests != 36 || metrics.MaxPerConnection != 36 {
+		t.Errorf("got %+v, want 36 requests on 1 connection", metrics)
+	}
+}
+
+func TestHTTP2Disabled(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Server.EnableHTTP2 = false
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	resp, err := newH2Client(t, proxy).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 {
+		t.Errorf("got %s, want HTTP/1.1 with enable_http2 off", resp.Proto)
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHeadFromCachedGet(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	body := strings.Repeat("head body ", 20)
+	var mu sync.Mutex
+	methods := map[string][]string{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods[r.URL.Path] = append(methods[r.URL.Path], r.Method)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableCompression: true}}
+
+	do := func(method, path, acceptEncoding string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, upstream.URL+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(data)
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		do(http.MethodGet, "/cached", "")
+		resp, data := do(http.MethodHead, "/cached", "")
+		if resp.Header.Get("X-Cache") != "HIT" {
+			t.Errorf("got X-Cache %q, want HIT", resp.Header.Get("X-Cache"))
+		}
+		if resp.ContentLength != int64(len(body)) {
+			t.Errorf("got Content-Length %d, want %d", resp.ContentLength, len(body))
+		}
+		if data != "" {
+			t.Errorf("expected no body, got %q", data)
+		}
+		if got := methods["/cached"]; len(got) != 1 {
+			t.Errorf("expected HEAD not to reach upstream, got %v", got)
+		}
+	})
+
+	t.Run("hit with encoding", func(t *testing.T) {
+		_, gzipped := do(http.MethodGet, "/cached", "gzip")
+		resp, _ := do(http.MethodHead, "/cached", "gzip")
+		if resp.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("got Content-Encoding %q, want gzip", resp.Header.Get("Content-Encoding"))
+		}
+		if resp.ContentLength != int64(len(gzipped)) {
+			t.Errorf("got Content-Length %d, want the gzip length %d", resp.ContentLength, len(gzipped))
+		}
+	})
+
+	t.Run("miss does not poison GET", func(t *testing.T) {
+		resp, _ := do(http.MethodHead, "/uncached", "")
+		if resp.Header.Get("X-Cache") != "" {
+			t.Errorf("expected a forwarded HEAD to have no X-Cache header, got %q", resp.Header.Get("X-Cache"))
+		}
+		if resp.ContentLength != int64(len(body)) {
+			t.Errorf("got Content-Length %d, want the upstream length", resp.ContentLength)
+		}
+		resp, data := do(http.MethodGet, "/uncached", "")
+		if resp.Header.Get("X-Cache") != "MISS" || data != body {
+			t.Errorf("expected GET after HEAD to fetch the full body, got X-Cache %q and %d bytes", resp.Header.Get("X-Cache"), len(data))
+		}
+		if got := methods["/uncached"]; strings.Join(got, ",") != "HEAD,GET" {
+			t.Errorf("got upstream methods %v, want [HEAD GET]", got)
+		}
+	})
+}
+
+func TestHeadFromCachedGetHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("secure body"))
+	}))
+	defer upstream.Close()
+
+	client := newMITMClient(t, proxy)
+	resp, err := client.Get(upstream.URL + "/")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	resp, err = client.Head(upstream.URL + "/")
+	if err != nil {
+		t.Fatalf("HEAD failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("got X-Cache %q, want HIT", resp.Header.Get("X-Cache"))
+	}
+	if resp.ContentLength != int64(len("secure body")) {
+		t.Errorf("got Content-Length %d, want %d", resp.ContentLength, len("secure body"))
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// GetInvalidationCount returns the number of cache entries removed after
+// unsafe requests.
+func (p *Proxy) GetInvalidationCount() uint64 {
+	return p.invalidations.Load()
+}
+
+// isSafeMethod reports whether a request method is safe (RFC 9110 section
+// 9.2.1), so it cannot change the resources it targets. Besides the standard
+// methods, this covers the registered safe methods that may be listed in
+// cacheable_methods.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		"QUERY", "PROPFIND", "REPORT", "SEARCH":
+		return true
+	}
+	return false
+}
+
+// invalidateEnabled reports whether unsafe requests like r invalidate cached
+// responses, taking a matching rule's invalidate_on_unsafe setting into
+// account.
+func (p *Proxy) invalidateEnabled(r *http.Request) bool {
+	if rule := p.ruleFor(r); rule != nil && rule.InvalidateOnUnsafe != nil {
+		return *rule.InvalidateOnUnsafe
+	}
+	return p.config.Cache.InvalidateOnUnsafe
+}
+
+// invalidate removes the responses cached in c for the target URI of an
+// unsafe request r, and for the URIs in the Location and Content-Location
+// headers of its response when they have the same origin (RFC 9111 section
+// 4.4). Error responses leave the cache untouched.
+func (p *Proxy) invalidate(c *cache.MemoryCache, r *http.Request, resp *http.Response) {
+	if isSafeMethod(r.Method) || resp.StatusCode >= 400 || !p.invalidateEnabled(r) {
+		return
+	}
+	targets := []*url.URL{r.URL}
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if u, err := r.URL.Parse(value); err == nil && sameOrigin(u, r.URL) {
+			targets = append(targets, u)
+		}
+	}
+	for _, u := range targets {
+		key := p.baseCacheKey(u)
+		if n := c.PurgeVariants(key); n > 0 {
+			p.invalidations.Add(uint64(n))
+			p.logger.Info("cache entries invalidated", "key", key, "method", r.Method, "count", n)
+		}
+	}
+}
+
+// sameOrigin reports whether two absolute URLs have the same scheme, host and
+// port.
+func sameOrigin(a, b *url.URL) bool {
+	if !strings.EqualFold(a.Scheme, b.Scheme) {
+		return false
+	}
+	scheme := strings.ToLower(a.Scheme)
+	return normalizeHostPort(scheme, a.Host, true, true) == normalizeHostPort(scheme, b.Host, true, true)
+}
+
+// baseCacheKey returns the cache key of the GET response for u, without the
+// folded headers and cookies that distinguish its variants.
+func (p *Proxy) baseCacheKey(u *url.URL) string {
+	r := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}
+	rules := p.keyRulesFor(r)
+	rules.headers, rules.cookies, rules.keyExtra = nil, nil, ""
+	return buildCacheKey(r, rules)
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"http://example.com/a", "http://example.com/b", true},
+		{"http://EXAMPLE.com:80/a", "http://example.com/b", true},
+		{"https://example.com/a", "http://example.com/a", false},
+		{"http://example.com:8080/a", "http://example.com/a", false},
+		{"http://other.com/a", "http://example.com/a", false},
+	}
+	for _, tt := range tests {
+		a, _ := url.Parse(tt.a)
+		b, _ := url.Parse(tt.b)
+		if got := sameOrigin(a, b); got != tt.want {
+			t.Errorf("sameOrigin(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnsafeMethodInvalidation(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte("item " + r.URL.Path))
+		case r.URL.Path == "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/items":
+			w.Header().Set("Location", "/items/new")
+			w.Header().Set("Content-Location", "http://other.example/items/new")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	do := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, server.URL+path, strings.NewReader("data"))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	cached := func(path string) bool {
+		return do(http.MethodGet, path, nil).Header().Get("X-Cache") == "HIT"
+	}
+	warm := func(t *testing.T, paths ...string) {
+		t.Helper()
+		for _, path := range paths {
+			do(http.MethodGet, path, nil)
+			if !cached(path) {
+				t.Fatalf("expected %s to be cached", path)
+			}
+		}
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			warm(t, "/items/1")
+			before := proxy.GetInvalidationCount()
+			do(method, "/items/1", nil)
+			if cached("/items/1") {
+				t.Errorf("expected %s to invalidate the cached GET", method)
+			}
+			if got := proxy.GetInvalidationCount() - before; got != 1 {
+				t.Errorf("got %d invalidations, want 1", got)
+			}
+		})
+	}
+
+	t.Run("location headers", func(t *testing.T) {
+		warm(t, "/items", "/items/new")
+		key := proxy.cacheKey(httptest.NewRequest(http.MethodGet, "http://other.example/items/new", nil))
+		proxy.cache.Set(key, cache.CacheEntry{StatusCode: http.StatusOK, Body: []byte("other")})
+		do(http.MethodPost, "/items", nil)
+		if cached("/items") || cached("/items/new") {
+			t.Error("expected the target and Location entries to be invalidated")
+		}
+		if _, ok := proxy.cache.Peek(key); !ok {
+			t.Error("expected a cross-origin Content-Location to be left alone")
+		}
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		warm(t, "/fail")
+		do(http.MethodPut, "/fail", nil)
+		if !cached("/fail") {
+			t.Error("expected an error response not to invalidate")
+		}
+	})
+
+	t.Run("safe method", func(t *testing.T) {
+		warm(t, "/items/2")
+		do(http.MethodOptions, "/items/2", nil)
+		if !cached("/items/2") {
+			t.Error("expected OPTIONS not to invalidate")
+		}
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		proxy.config.Cache.Key.Headers = []string{"Accept-Language"}
+		defer func() { proxy.config.Cache.Key.Headers = nil }()
+		for _, lang := range []string{"en", "fr"} {
+			do(http.MethodGet, "/items/3", http.Header{"Accept-Language": {lang}})
+		}
+		do(http.MethodPut, "/items/3", nil)
+		for _, lang := range []string{"en", "fr"} {
+			if w := do(http.MethodGet, "/items/3", http.Header{"Accept-Language": {lang}}); w.Header().Get("X-Cache") == "HIT" {
+				t.Errorf("expected the %s variant to be invalidated", lang)
+			}
+		}
+	})
+
+	t.Run("disabled by rule", func(t *testing.T) {
+		no := false
+		proxy.config.Cache.Rules = []config.CacheRule{{Name: "keep", PathPrefix: "/keep", InvalidateOnUnsafe: &no}}
+		defer func() { proxy.config.Cache.Rules = nil }()
+		warm(t, "/keep")
+		do(http.MethodPut, "/keep", nil)
+		if !cached("/keep") {
+			t.Error("expected the rule to disable invalidation")
+		}
+	})
+
+	t.Run("disabled globally", func(t *testing.T) {
+		proxy.config.Cache.InvalidateOnUnsafe = false
+		defer func() { proxy.config.Cache.InvalidateOnUnsafe = true }()
+		warm(t, "/items/4")
+		do(http.MethodPut, "/items/4", nil)
+		if !cached("/items/4") {
+			t.Error("expected invalidate_on_unsafe = false to disable invalidation")
+		}
+	})
+}
+
+func TestUnsafeMethodInvalidationHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	client := newMITMClient(t, proxy)
+	do := func(method string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+"/resource", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	do(http.MethodGet)
+	do(http.MethodDelete)
+	if resp := do(http.MethodGet); resp.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("got X-Cache %q after DELETE, want MISS", resp.Header.Get("X-Cache"))
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// maxDrainBytes is how much of a request body left unread by its handler is
+// discarded to keep the connection usable. Larger bodies close it instead.
+const maxDrainBytes = 256 << 10
+
+// MITMConnectionMetrics describes the intercepted HTTPS connections the proxy
+// has served.
+type MITMConnectionMetrics struct {
+	Connections      uint64 // Connections accepted
+	Open             int64  // Connections currently open
+	Requests         uint64 // Requests read off all connections
+	MaxPerConnection uint64 // Most requests read off a single connection
+}
+
+// GetMITMConnectionMetrics returns the intercepted HTTPS connection metrics.
+func (p *Proxy) GetMITMConnectionMetrics() MITMConnectionMetrics {
+	return MITMConnectionMetrics{
+		Connections:      p.mitmConnections.Load(),
+		Open:             p.mitmOpen.Load(),
+		Requests:         p.mitmRequests.Load(),
+		MaxPerConnection: p.mitmMaxRequests.Load(),
+	}
+}
+
+// serveMITMConn reads requests off the decrypted connection of the CONNECT
+// request r and answers them in order, until the client closes the
+// connection, asks for it to be closed, or leaves it idle for longer than the
+// idle timeout. Pipelined requests are answered in the order they were sent,
+// since each response is written before the next request is read. Clients
+// that negotiate HTTP/2 are handed to serveH2.
+func (p *Proxy) serveMITMConn(tlsConn *tls.Conn, r *http.Request) {
+	p.mitmConnections.Add(1)
+	p.mitmOpen.Add(1)
+	defer p.mitmOpen.Add(-1)
+
+	idleTimeout := p.config.Server.GetIdleTimeout()
+	if idleTimeout > 0 {
+		tlsConn.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		p.logger.Error("tls handshake failed", "host", r.Host, "error", err)
+		p.rememberRejection(r, err)
+		return
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		tlsConn.SetReadDeadline(time.Time{})
+		p.serveH2(tlsConn, r, idleTimeout)
+		return
+	}
+
+	reader := bufio.NewReader(tlsConn)
+	var served uint64
+	for {
+		if idleTimeout > 0 {
+			tlsConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				p.logger.Debug("idle https connection closed", "host", r.Host, "requests", served)
+			case err != io.EOF && served == 0:
+				p.logger.Error("failed to read https request", "error", err)
+			case err != io.EOF:
+				p.logger.Debug("https connection closed", "host", r.Host, "requests", served, "error", err)
+			}
+			return
+		}
+		// Handlers may take as long as they need to read the body
+		tlsConn.SetReadDeadline(time.Time{})
+
+		served++
+		p.mitmRequests.Add(1)
+		p.recordMaxRequests(served)
+
+		body := req.Body
+		var ok bool
+		if isUpgrade(req) {
+			ok = p.serveMITMUpgrade(tlsConn, reader, r, req)
+		} else {
+			ok = p.serveMITMRequest(tlsConn, r, req)
+		}
+		if !ok || !keepAlive(req) || !drainBody(body) {
+			return
+		}
+	}
+}
+
+// recordMaxRequests raises the most requests seen on one connection to n.
+func (p *Proxy) recordMaxRequests(n uint64) {
+	for {
+		current := p.mitmMaxRequests.Load()
+		if n <= current || p.mitmMaxRequests.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+// keepAlive reports whether the connection req was read from may carry
+// another request after its response.
+func keepAlive(req *http.Request) bool {
+	return !req.Close && req.ProtoAtLeast(1, 1)
+}
+
+// drainBody discards what is left of a request body so the next request on
+// the connection can be read. It reports false if the body is too large to
+// drain or could not be read.
+func drainBody(body io.ReadCloser) bool {
+	n, err := io.CopyN(io.Discard, body, maxDrainBytes+1)
+	if errors.Is(err, http.ErrBodyReadAfterClose) {
+		return true // Closing a body reads it to the end
+	}
+	return err == io.EOF && n <= maxDrainBytes
+}
+
+// writeConnError writes a plain text error response to a hijacked connection,
+// like http.Error, and returns the size of its body.
+func writeConnError(conn io.Writer, req *http.Request, message string, code int) (int64, error) {
+	cw := newConnResponseWriter(conn, req)
+	http.Error(cw, message, code)
+	return cw.size, cw.finish()
+}
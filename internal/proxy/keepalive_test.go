@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialMITM opens a CONNECT tunnel through p to target's host and returns the
+// decrypted connection.
+func dialMITM(t *testing.T, p *Proxy, target string) (*tls.Conn, *bufio.Reader) {
+	t.Helper()
+	proxyServer := httptest.NewServer(p)
+	t.Cleanup(proxyServer.Close)
+	p.SetTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})
+
+	u, _ := url.Parse(target)
+	conn, err := net.Dial("tcp", proxyServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", u.Host, u.Host)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(p.GetCA())
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: u.Hostname()})
+	t.Cleanup(func() { tlsConn.Close() })
+	return tlsConn, bufio.NewReader(tlsConn)
+}
+
+func TestMITMKeepAlive(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.HonorClientDirectives = true
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	defer server.Close()
+
+	client := newMITMClient(t, proxy)
+	requests := []struct {
+		method, path, body, want string
+	}{
+		{http.MethodGet, "/a", "", "GET /a "},
+		{http.MethodGet, "/a", "", "GET /a "},
+		{http.MethodPut, "/b", "data", "PUT /b data"},
+		{http.MethodGet, "/c", "", "GET /c "},
+	}
+	for _, tt := range requests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", tt.method, tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, body, tt.want)
+		}
+		for _, name := range []string{"Keep-Alive", "Connection", "X-Hop"} {
+			if resp.Header.Get(name) != "" {
+				t.Errorf("%s %s: expected hop-by-hop header %s to be removed", tt.method, tt.path, name)
+			}
+		}
+	}
+
+	// Only-if-cached misses are answered without closing the connection too
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/uncached", nil)
+	req.Header.Set("Cache-Control", "only-if-cached")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("only-if-cached request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+
+	metrics := proxy.GetMITMConnectionMetrics()
+	if metrics.Connections != 1 || metrics.Requests != 5 || metrics.MaxPerConnection != 5 {
+		t.Errorf("got %+v, want 5 requests on 1 connection", metrics)
+	}
+}
+
+func TestMITMPipelining(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer server.Close()
+
+	conn, reader := dialMITM(t, proxy, server.URL)
+	host := strings.TrimPrefix(server.URL, "https://")
+	pipelined := "GET /slow HTTP/1.1\r\nHost: " + host + "\r\n\r\n" +
+		"POST /post HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 4\r\n\r\nbody" +
+		"GET /last HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n\r\n"
+	if _, err := io.WriteString(conn, pipelined); err != nil {
+		t.Fatalf("failed to write requests: %v", err)
+	}
+
+	for _, want := range []string{"/slow ", "/post body", "/last "} {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("failed to read response for %q: %v", want, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("got %q, want %q", body, want)
+		}
+		if want == "/last " && !resp.Close {
+			t.Error("expected Connection: close on the response to a closing request")
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("expected the proxy to close the connection, got %v", err)
+	}
+}
+
+func TestMITMIdleTimeout(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Server.IdleTimeout = "100ms"
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	conn, reader := dialMITM(t, proxy, server.URL)
+	host := strings.TrimPrefix(server.URL, "https://")
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.Close {
+		t.Error("expected the connection to be kept alive")
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("expected the idle connection to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("idle connection closed after %v, want about 100ms", elapsed)
+	}
+}
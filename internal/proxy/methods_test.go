@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+func TestCacheableMethods(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.CacheableMethods = []string{"GET", "query", "POST"}
+
+	var upstreamRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"method":"` + r.Method + `","body":"` + string(body) + `"}`))
+	}))
+	defer server.Close()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, server.URL+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("keyed by body", func(t *testing.T) {
+		do("QUERY", "/search", "a")
+		if w := do("QUERY", "/search", "a"); w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("got X-Cache %q for a repeated QUERY, want HIT", w.Header().Get("X-Cache"))
+		}
+		if w := do("QUERY", "/search", "b"); w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("got X-Cache %q for a different body, want MISS", w.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("methods do not share entries", func(t *testing.T) {
+		w := do(http.MethodPost, "/search", "a")
+		if w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), `"method":"POST"`) {
+			t.Errorf("expected POST to miss the QUERY entry, got X-Cache %q and %s", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		if w := do(http.MethodPost, "/search", "a"); w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("expected POST listed in cacheable_methods to be cached, got X-Cache %q", w.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("safe methods do not invalidate", func(t *testing.T) {
+		do(http.MethodGet, "/search", "")
+		do("QUERY", "/search", "c")
+		if w := do(http.MethodGet, "/search", ""); w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("expected QUERY to leave the cached GET alone, got X-Cache %q", w.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("unlisted method", func(t *testing.T) {
+		before := upstreamRequests.Load()
+		do("REPORT", "/search", "a")
+		w := do("REPORT", "/search", "a")
+		if w.Header().Get("X-Cache") != "" || upstreamRequests.Load()-before != 2 {
+			t.Errorf("expected REPORT to be forwarded uncached, got X-Cache %q", w.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("never_cache rule", func(t *testing.T) {
+		proxy.config.Cache.Rules = []config.CacheRule{{Name: "live", PathPrefix: "/live", NeverCache: true}}
+		defer func() { proxy.config.Cache.Rules = nil }()
+		do("QUERY", "/live", "a")
+		if w := do("QUERY", "/live", "a"); w.Header().Get("X-Cache") == "HIT" {
+			t.Error("expected never_cache to apply to QUERY")
+		}
+	})
+
+	t.Run("GET removed from the list", func(t *testing.T) {
+		proxy.config.Cache.CacheableMethods = []string{"QUERY"}
+		defer func() { proxy.config.Cache.CacheableMethods = []string{"GET", "query", "POST"} }()
+		do(http.MethodGet, "/plain", "")
+		if w := do(http.MethodGet, "/plain", ""); w.Header().Get("X-Cache") != "" {
+			t.Errorf("expected GET not to be cached, got X-Cache %q", w.Header().Get("X-Cache"))
+		}
+	})
+}
+
+func TestCacheableMethodsHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.CacheableMethods = []string{"GET", "PROPFIND"}
+
+	var upstreamRequests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte("<multistatus/>"))
+	}))
+	defer server.Close()
+	proxy.config.Cache.CacheableTypes = append(proxy.config.Cache.CacheableTypes, "application/xml")
+
+	client := newMITMClient(t, proxy)
+	for _, want := range []string{"MISS", "HIT"} {
+		req, _ := http.NewRequest("PROPFIND", server.URL+"/dav/", strings.NewReader(`<propfind/>`))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("PROPFIND failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("X-Cache") != want || string(body) != "<multistatus/>" {
+			t.Errorf("got X-Cache %q and %q, want %s", resp.Header.Get("X-Cache"), body, want)
+		}
+	}
+	if n := upstreamRequests.Load(); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// NamespaceHeader selects the cache namespace for a request. It is removed
+// before the request is forwarded upstream.
+const NamespaceHeader = "X-GoCache-Namespace"
+
+// Namespaces returns the registry of namespace caches served by the proxy.
+func (p *Proxy) Namespaces() *cache.Registry {
+	return p.namespaces
+}
+
+// namespaceCache returns the cache for a namespace name.
+func (p *Proxy) namespaceCache(name string) (*cache.MemoryCache, bool) {
+	if p.namespaces == nil {
+		if name == "" || name == cache.DefaultNamespace {
+			return p.cache, true
+		}
+		return nil, false
+	}
+	return p.namespaces.Get(name)
+}
+
+// resolveNamespace picks the namespace for r. The X-GoCache-Namespace header
+// wins, then the proxy-auth username, then the port the client connected to.
+// conn is the request that arrived on the client connection: r itself for
+// plain HTTP, or the CONNECT request for intercepted HTTPS.
+func (p *Proxy) resolveNamespace(r, conn *http.Request) (string, *cache.MemoryCache, error) {
+	if name := r.Header.Get(NamespaceHeader); name != "" {
+		r.Header.Del(NamespaceHeader)
+		c, ok := p.namespaceCache(name)
+		if !ok {
+			return "", nil, fmt.Errorf("unknown cache namespace %q", name)
+		}
+		return name, c, nil
+	}
+
+	if user := proxyAuthUser(conn.Header); user != "" {
+		if c, ok := p.namespaceCache(user); ok {
+			return user, c, nil
+		}
+	}
+
+	if addr, ok := conn.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			if name, ok := p.config.NamespaceForPort(tcpAddr.Port); ok {
+				if c, ok := p.namespaceCache(name); ok {
+					return name, c, nil
+				}
+			}
+		}
+	}
+
+	c, _ := p.namespaceCache(cache.DefaultNamespace)
+	return cache.DefaultNamespace, c, nil
+}
+
+// proxyAuthUser returns the username from a Basic Proxy-Authorization header.
+func proxyAuthUser(h http.Header) string {
+	encoded, ok := strings.CutPrefix(h.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user
+}
+
+// negativeTTL returns the TTL for an error response to r in a namespace. The
+// X-GoCache-TTL header takes precedence, then a matching cache rule, then the
+// status_ttl table, then the namespace setting.
+func (p *Proxy) negativeTTL(namespace string, r *http.Request, statusCode int) time.Duration {
+	if ttl := controlsFrom(r).ttl; ttl > 0 {
+		return ttl
+	}
+	if rule := p.ruleFor(r); rule != nil {
+		if ttl, ok := rule.GetNegativeTTL(); ok {
+			return ttl
+		}
+	}
+	if ttl, ok := p.config.Cache.GetStatusTTL(statusCode); ok {
+		return ttl
+	}
+	if ns, ok := p.config.Namespaces[namespace]; ok {
+		return ns.GetNegativeTTL(&p.config.Cache)
+	}
+	return p.config.Cache.GetNegativeTTL()
+}
+
+// StartNamespaceListener serves proxy requests on an additional address whose
+// requests default to the given namespace. It blocks like Start, and returns
+// http.ErrServerClosed once Shutdown was called, even if that happened before
+// the listener started.
+func (p *Proxy) StartNamespaceListener(namespace, addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: p,
+	}
+	p.listenersMu.Lock()
+	if p.listenersClosed {
+		p.listenersMu.Unlock()
+		return http.ErrServerClosed
+	}
+	p.listeners = append(p.listeners, srv)
+	p.listenersMu.Unlock()
+	p.logger.Info("namespace proxy listener starting", "namespace", namespace, "address", addr)
+	return srv.ListenAndServe()
+}
+
+// shutdownListeners stops the servers started by StartNamespaceListener.
+func (p *Proxy) shutdownListeners(ctx context.Context) error {
+	p.listenersMu.Lock()
+	defer p.listenersMu.Unlock()
+	p.listenersClosed = true
+	var firstErr error
+	for _, srv := range p.listeners {
+		if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
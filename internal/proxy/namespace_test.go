@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+func TestNamespaces(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	var gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get(NamespaceHeader)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	projectA := cache.NewMemoryCache(time.Minute, 0)
+	projectB := cache.NewMemoryCache(time.Minute, 0)
+	proxy.Namespaces().Add("project-a", projectA)
+	proxy.Namespaces().Add("project-b", projectB)
+	proxy.config.Namespaces = map[string]config.NamespaceConfig{
+		"project-a": {},
+		"project-b": {ProxyPort: 9191},
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("header selects namespace and is not forwarded", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/header", nil)
+		req.Header.Set(NamespaceHeader, "project-a")
+		if w := serve(req); w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+		if gotHeader != "" {
+			t.Errorf("namespace header leaked upstream: %q", gotHeader)
+		}
+		if _, ok := projectA.Get(server.URL + "/header"); !ok {
+			t.Error("expected entry in project-a")
+		}
+		if _, ok := proxy.cache.Get(server.URL + "/header"); ok {
+			t.Error("entry must not be stored in the default namespace")
+		}
+	})
+
+	t.Run("proxy-auth username selects namespace", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/auth", nil)
+		req.SetBasicAuth("project-b", "secret")
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+		serve(req)
+		if _, ok := projectB.Get(server.URL + "/auth"); !ok {
+			t.Error("expected entry in project-b")
+		}
+	})
+
+	t.Run("unknown username falls back to default", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/stranger", nil)
+		req.SetBasicAuth("stranger", "secret")
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		serve(req)
+		if _, ok := proxy.cache.Get(server.URL + "/stranger"); !ok {
+			t.Error("expected entry in the default namespace")
+		}
+	})
+
+	t.Run("listening port selects namespace", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/port", nil)
+		local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9191}
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+		serve(req)
+		if _, ok := projectB.Get(server.URL + "/port"); !ok {
+			t.Error("expected entry in project-b")
+		}
+	})
+
+	t.Run("header wins over port", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/both", nil)
+		req.Header.Set(NamespaceHeader, "project-a")
+		local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9191}
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+		serve(req)
+		if _, ok := projectA.Get(server.URL + "/both"); !ok {
+			t.Error("expected entry in project-a")
+		}
+	})
+
+	t.Run("unknown namespace header is rejected", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/unknown", nil)
+		req.Header.Set(NamespaceHeader, "nope")
+		if w := serve(req); w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("namespace negative TTL", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		proxy.config.Namespaces["project-a"] = config.NamespaceConfig{NegativeTTL: "42s"}
+		if got := proxy.negativeTTL("project-a", req, http.StatusNotFound); got != 42*time.Second {
+			t.Errorf("got %v, want 42s", got)
+		}
+		if got := proxy.negativeTTL(cache.DefaultNamespace, req, http.StatusNotFound); got != proxy.config.Cache.GetNegativeTTL() {
+			t.Errorf("got %v, want global negative TTL", got)
+		}
+	})
+}
+
+func TestFetchNamespace(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	projectA := cache.NewMemoryCache(time.Minute, 0)
+	proxy.Namespaces().Add("project-a", projectA)
+
+	if _, err := proxy.Fetch(context.Background(), "project-a", server.URL+"/warm", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := projectA.Get(server.URL + "/warm"); !ok {
+		t.Error("expected warmed entry in project-a")
+	}
+}
+
+func TestNamespaceListenerShutdown(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- proxy.StartNamespaceListener("project-a", addr) }()
+	waitFor(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+
+	if err := proxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != http.ErrServerClosed {
+			t.Errorf("got %v, want %v", err, http.ErrServerClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("namespace listener still running after shutdown")
+	}
+
+	// A listener that starts after shutdown, such as one still being set up
+	// when a signal arrived, must not serve
+	if err := proxy.StartNamespaceListener("project-b", addr); err != http.ErrServerClosed {
+		t.Errorf("got %v, want %v for a listener started after shutdown", err, http.ErrServerClosed)
+	}
+}
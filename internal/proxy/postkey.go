@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// canonicalPostBody returns body in canonical form for the POST cache key,
+// according to [cache.post_cache.canonicalize] and the Content-Type of r.
+// Bodies of other types, or that cannot be parsed, are returned unchanged.
+func (p *Proxy) canonicalPostBody(r *http.Request, body []byte) []byte {
+	cfg := p.config.Cache.PostCache.Canonicalize
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return body
+	}
+
+	var canonical []byte
+	switch {
+	case mediaType == "application/graphql" && cfg.GraphQL:
+		var query string
+		query, _, err = normalizeGraphQL(string(body))
+		canonical = []byte(query)
+	case (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && (cfg.JSON || cfg.GraphQL):
+		canonical, err = canonicalJSON(body, cfg.IgnoreJSONPaths, cfg.GraphQL)
+	case mediaType == "application/x-www-form-urlencoded" && cfg.Form:
+		canonical, err = canonicalForm(body)
+	default:
+		return body
+	}
+	if err != nil {
+		p.logger.Debug("POST body not canonicalized", "contentType", mediaType, "error", err)
+		return body
+	}
+	return canonical
+}
+
+// canonicalJSON re-encodes a JSON body with sorted object keys and no
+// insignificant whitespace, leaving out the members at ignorePaths. With
+// graphql set, GraphQL requests in the body are normalized as well.
+func canonicalJSON(body []byte, ignorePaths []string, graphql bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // Keep numbers exactly as sent
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	for _, path := range ignorePaths {
+		segments, err := parseJSONPath(path)
+		if err != nil {
+			continue
+		}
+		v = deleteJSONPath(v, segments)
+	}
+	if graphql {
+		v = canonicalGraphQLRequest(v)
+	}
+	return json.Marshal(v)
+}
+
+// parseJSONPath splits a path such as "$.items[*].id" into member names and
+// array indexes. "*" matches every member or element.
+func parseJSONPath(path string) ([]string, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+	if !ok {
+		return nil, fmt.Errorf("JSON path %q does not start with $", path)
+	}
+	var segments []string
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("JSON path %q has an empty member name", path)
+			}
+			segments = append(segments, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("JSON path %q has an unclosed bracket", path)
+			}
+			segments = append(segments, strings.Trim(rest[1:end], `'"`))
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("JSON path %q is malformed", path)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("JSON path %q selects the whole body", path)
+	}
+	return segments, nil
+}
+
+// deleteJSONPath removes the values at path from a decoded JSON value and
+// returns the result.
+func deleteJSONPath(v any, path []string) any {
+	segment, rest := path[0], path[1:]
+	switch node := v.(type) {
+	case map[string]any:
+		for key, child := range node {
+			if segment != "*" && segment != key {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(node, key)
+			} else {
+				node[key] = deleteJSONPath(child, rest)
+			}
+		}
+	case []any:
+		kept := node[:0]
+		for i, child := range node {
+			if segment != "*" && segment != strconv.Itoa(i) {
+				kept = append(kept, child)
+				continue
+			}
+			if len(rest) > 0 {
+				kept = append(kept, deleteJSONPath(child, rest))
+			}
+		}
+		return kept
+	}
+	return v
+}
+
+// canonicalGraphQLRequest normalizes the query of a GraphQL request object,
+// or of each request in a batch. operationName is dropped when the document
+// has a single operation, and so cannot select another one, and variables
+// are dropped when empty.
+func canonicalGraphQLRequest(v any) any {
+	switch node := v.(type) {
+	case []any:
+		for i := range node {
+			node[i] = canonicalGraphQLRequest(node[i])
+		}
+	case map[string]any:
+		query, ok := node["query"].(string)
+		if !ok {
+			break
+		}
+		normalized, operations, err := normalizeGraphQL(query)
+		if err != nil {
+			break
+		}
+		node["query"] = normalized
+		if operations <= 1 {
+			delete(node, "operationName")
+		}
+		if vars, ok := node["variables"]; ok {
+			if m, isMap := vars.(map[string]any); vars == nil || isMap && len(m) == 0 {
+				delete(node, "variables")
+			}
+		}
+	}
+	return v
+}
+
+// normalizeGraphQL rewrites a GraphQL document as its tokens separated by
+// single spaces, dropping comments, commas and other insignificant
+// characters. It also returns the number of operations the document defines.
+func normalizeGraphQL(src string) (string, int, error) {
+	var tokens []string
+	operations, braces, parens := 0, 0, 0
+	inDefinition := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		var tok string
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+			continue
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(src[i:], `"""`):
+			end, err := blockStringEnd(src, i+3)
+			if err != nil {
+				return "", 0, err
+			}
+			tok = src[i:end]
+		case c == '"':
+			end, err := stringEnd(src, i+1)
+			if err != nil {
+				return "", 0, err
+			}
+			tok = src[i:end]
+		case strings.HasPrefix(src[i:], "..."):
+			tok = "..."
+		case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+			tok = src[i : i+1]
+		case isNameStart(c):
+			end := i + 1
+			for end < len(src) && (isNameStart(src[end]) || isDigit(src[end])) {
+				end++
+			}
+			tok = src[i:end]
+		case c == '-' || isDigit(c):
+			end := i + 1
+			for end < len(src) && (isDigit(src[end]) || strings.IndexByte(".eE+-", src[end]) >= 0) {
+				end++
+			}
+			tok = src[i:end]
+		default:
+			return "", 0, fmt.Errorf("unexpected character %q in GraphQL document", c)
+		}
+		i += len(tok)
+
+		// Operations start at the top level with a keyword or, for the query
+		// shorthand, a selection set.
+		switch tok {
+		case "(":
+			parens++
+		case ")":
+			parens--
+		case "{":
+			if braces == 0 && parens == 0 && !inDefinition {
+				operations++
+				inDefinition = true
+			}
+			braces++
+		case "}":
+			braces--
+			if braces == 0 && parens == 0 {
+				inDefinition = false
+			}
+		case "query", "mutation", "subscription", "fragment":
+			if braces == 0 && parens == 0 && !inDefinition {
+				inDefinition = true
+				if tok != "fragment" {
+					operations++
+				}
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	return strings.Join(tokens, " "), operations, nil
+}
+
+// stringEnd returns the index just past the closing quote of a GraphQL string
+// whose contents start at i.
+func stringEnd(src string, i int) (int, error) {
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		case '\n', '\r':
+			return 0, errors.New("unterminated string in GraphQL document")
+		}
+	}
+	return 0, errors.New("unterminated string in GraphQL document")
+}
+
+// blockStringEnd returns the index just past the closing quotes of a GraphQL
+// block string whose contents start at i.
+func blockStringEnd(src string, i int) (int, error) {
+	for {
+		k := strings.Index(src[i:], `"""`)
+		if k < 0 {
+			return 0, errors.New("unterminated block string in GraphQL document")
+		}
+		if k > 0 && src[i+k-1] == '\\' {
+			i += k + 3 // An escaped \"""
+			continue
+		}
+		return i + k + 3, nil
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// canonicalForm sorts the fields of an application/x-www-form-urlencoded
+// body by name, keeping the order of repeated fields.
+func canonicalForm(body []byte) ([]byte, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
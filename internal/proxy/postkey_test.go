@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeGraphQL(t *testing.T) {
+	tests := []struct {
+		query      string
+		want       string
+		operations int
+	}{
+		{"{ a }", "{ a }", 1},
+		{"query Q {\n  a, b # comment\n}", "query Q { a b }", 1},
+		{`query($id: ID = "x, y") { a(id: $id) { ...F } } fragment F on T { b }`, `query ( $ id : ID = "x, y" ) { a ( id : $ id ) { ... F } } fragment F on T { b }`, 1},
+		{"query A { a } query B { b }", "query A { a } query B { b }", 2},
+		{`query Q($in: In = {query: 1}) @dir { query }`, `query Q ( $ in : In = { query : 1 } ) @ dir { query }`, 1},
+		{`{ a(s: """block "" string""") }`, `{ a ( s : """block "" string""" ) }`, 1},
+		{"mutation { m(n: -1.5e+3) }", "mutation { m ( n : -1.5e+3 ) }", 1},
+	}
+	for _, tt := range tests {
+		got, operations, err := normalizeGraphQL(tt.query)
+		if err != nil {
+			t.Errorf("normalizeGraphQL(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if got != tt.want || operations != tt.operations {
+			t.Errorf("normalizeGraphQL(%q) = %q, %d; want %q, %d", tt.query, got, operations, tt.want, tt.operations)
+		}
+	}
+
+	if _, _, err := normalizeGraphQL(`{ a(s: "unterminated) }`); err == nil {
+		t.Error("expected an unterminated string to fail")
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		ignore  []string
+		graphql bool
+		want    string
+	}{
+		{"sorted keys", `{ "b": 1, "a": [1.50, {"d": true, "c": null}] }`, nil, false, `{"a":[1.50,{"c":null,"d":true}],"b":1}`},
+		{"ignored paths", `{"requestId": "r1", "meta": {"ts": 1, "keep": 2}, "items": [{"id": 1, "x": 1}]}`, []string{"$.requestId", "$.meta.ts", "$.items[*].id"}, false, `{"items":[{"x":1}],"meta":{"keep":2}}`},
+		{"array element", `{"a": [1, 2, 3]}`, []string{"$.a[1]"}, false, `{"a":[1,3]}`},
+		{"invalid path skipped", `{"a": 1}`, []string{"a", "$."}, false, `{"a":1}`},
+		{"graphql single operation", `{"query": "query Q {\n a\n}", "operationName": "Q", "variables": {}}`, nil, true, `{"query":"query Q { a }"}`},
+		{"graphql keeps operationName", `{"query": "query A { a } query B { b }", "operationName": "B"}`, nil, true, `{"operationName":"B","query":"query A { a } query B { b }"}`},
+		{"graphql batch", `[{"query": "{a}", "variables": null}]`, nil, true, `[{"query":"{ a }"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalJSON([]byte(tt.body), tt.ignore, tt.graphql)
+			if err != nil {
+				t.Fatalf("canonicalJSON failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := canonicalJSON([]byte(`{"a": 1} {"b": 2}`), nil, false); err == nil {
+		t.Error("expected trailing data to fail")
+	}
+}
+
+func TestCanonicalPostCacheKeys(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.PostCache.Enable = true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{}}`))
+	}))
+	defer server.Close()
+
+	key := func(contentType, body string) string {
+		r := httptest.NewRequest(http.MethodPost, server.URL+"/graphql", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		return proxy.bodyCacheKey(r, []byte(body))
+	}
+	pairs := []struct {
+		name, contentType, a, b string
+	}{
+		{"json", "application/json; charset=utf-8", `{"a": 1, "b": 2, "requestId": "x"}`, `{"b":2,"a":1,"requestId":"y"}`},
+		{"graphql json", "application/json", `{"query": "{ user { id } }", "operationName": null}`, `{"query":"{user{id}}","operationName":"Anonymous"}`},
+		{"graphql", "application/graphql", "query {\n  user { id }\n}", "query { user { id } }"},
+		{"form", "application/x-www-form-urlencoded", "b=2&a=1", "a=1&b=2"},
+	}
+
+	for _, tt := range pairs {
+		if key(tt.contentType, tt.a) == key(tt.contentType, tt.b) {
+			t.Errorf("%s: expected raw bodies to differ while canonicalization is off", tt.name)
+		}
+	}
+
+	proxy.config.Cache.PostCache.Canonicalize.JSON = true
+	proxy.config.Cache.PostCache.Canonicalize.GraphQL = true
+	proxy.config.Cache.PostCache.Canonicalize.Form = true
+	proxy.config.Cache.PostCache.Canonicalize.IgnoreJSONPaths = []string{"$.requestId"}
+	for _, tt := range pairs {
+		if key(tt.contentType, tt.a) != key(tt.contentType, tt.b) {
+			t.Errorf("%s: expected equivalent bodies to share a key", tt.name)
+		}
+	}
+	if key("application/json", `{"a": 1}`) == key("application/json", `{"a": 2}`) {
+		t.Error("expected different JSON bodies to get different keys")
+	}
+	if key("text/plain", `{"a": 1}`) == key("text/plain", `{"a":1}`) {
+		t.Error("expected other content types to be hashed as sent")
+	}
+	if key("application/json", `{"a": 1`) == key("application/json", `{"a":1`) {
+		t.Error("expected invalid JSON to be hashed as sent")
+	}
+
+	post := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, server.URL+"/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Header().Get("X-Cache")
+	}
+	post(`{"query": "{ a }", "requestId": "1"}`)
+	if got := post(`{"requestId": "2", "query": "{a}"}`); got != "HIT" {
+		t.Errorf("got X-Cache %q for an equivalent request, want HIT", got)
+	}
+}
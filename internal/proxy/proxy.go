@@ -1,7 +1,6 @@
 package proxy
 
 import (
-	"bufio"
 	"bytes"
 	"container/list"
 	"context"
@@ -10,11 +9,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
-	"sort"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,19 +35,42 @@ type certNode struct {
 
 // Proxy is the main proxy server struct.
 type Proxy struct {
-	logger    *slog.Logger
-	config    *config.Config
-	cache     *cache.MemoryCache
-	accessLog *logging.AccessLogger
-	ca        *x509.Certificate
-	caPrivKey *rsa.PrivateKey
-	server    *http.Server
-	transport http.RoundTripper
+	logger     *slog.Logger
+	config     *config.Config
+	cache      *cache.MemoryCache // Default namespace
+	namespaces *cache.Registry
+	accessLog  *logging.AccessLogger
+	ca         *x509.Certificate
+	caPrivKey  *rsa.PrivateKey
+	server     *http.Server
+	transport  http.RoundTripper
 
 	certCache     map[string]*list.Element // Maps hostname -> list element
 	certLRUList   *list.List               // Doubly-linked list (head=recent, tail=old)
 	certCacheMu   sync.RWMutex
 	certEvictions atomic.Uint64 // Eviction counter for metrics
+
+	refreshMu       sync.Mutex
+	refreshInFlight map[string]bool // Keys with a refresh-ahead fetch running
+	refreshes       atomic.Uint64
+	refreshFailures atomic.Uint64
+	invalidations   atomic.Uint64 // Entries removed after unsafe requests
+
+	mitmConnections atomic.Uint64 // Intercepted HTTPS connections accepted
+	mitmOpen        atomic.Int64
+	mitmRequests    atomic.Uint64
+	mitmMaxRequests atomic.Uint64 // Most requests served on one connection
+	tunnels         atomic.Uint64 // CONNECT requests relayed without interception
+	rejectedHosts   sync.Map      // Hosts whose clients rejected the proxy's certificate
+	certRejections  atomic.Uint64 // TLS handshakes aborted by clients rejecting the certificate
+	upgrades        atomic.Uint64 // Connections that switched protocols, such as WebSockets
+
+	captureMu   sync.Mutex
+	captureFile *os.File // WebSocket capture file, opened on first use
+
+	listenersMu     sync.Mutex
+	listeners       []*http.Server // Namespace listeners started with StartNamespaceListener
+	listenersClosed bool           // Set by Shutdown; later listeners are not started
 }
 
 // NewProxy creates a new Proxy server.
@@ -86,13 +110,16 @@ func NewProxy(logger *slog.Logger, c *cache.MemoryCache, cfg *config.Config) (*P
 		logger:      logger,
 		config:      cfg,
 		cache:       c,
+		namespaces:  cache.NewRegistry(c),
 		accessLog:   accessLog,
 		ca:          ca,
 		caPrivKey:   caPrivKey,
 		certCache:   make(map[string]*list.Element),
 		certLRUList: list.New(),
-		transport:   http.DefaultTransport,
+
+		refreshInFlight: make(map[string]bool),
 	}
+	p.transport = p.newTransport()
 	p.server = &http.Server{Handler: p}
 	return p, nil
 }
@@ -118,9 +145,12 @@ func (p *Proxy) GetCertCacheMetrics() (int, uint64) {
 	return size, evictions
 }
 
-// SetConfig updates the proxy's configuration.
+// SetConfig updates the proxy's configuration. Hosts tunneled because their
+// clients rejected the proxy's certificate are intercepted again, so
+// installing the CA and reloading brings their caching back.
 func (p *Proxy) SetConfig(cfg *config.Config) {
 	p.config = cfg
+	p.rejectedHosts.Clear()
 }
 
 // SetTransport sets the transport for the proxy.
@@ -130,9 +160,12 @@ func (p *Proxy) SetTransport(transport http.RoundTripper) {
 
 // Close gracefully shuts down the proxy and its components
 func (p *Proxy) Close() error {
-	if p.cache != nil {
+	if p.namespaces != nil {
+		p.namespaces.Shutdown()
+	} else if p.cache != nil {
 		p.cache.Shutdown()
 	}
+	p.closeCapture()
 	if p.accessLog != nil {
 		return p.accessLog.Close()
 	}
@@ -153,31 +186,49 @@ func (p *Proxy) logAccess(startTime time.Time, r *http.Request, statusCode int,
 			fullURL = scheme + "://" + r.Host + r.URL.String()
 		}
 
-		p.accessLog.LogRequest(r.Method, fullURL, cacheStatus, statusCode, responseSize, duration, contentType)
+		var ruleName string
+		if rule := p.ruleFor(r); rule != nil {
+			ruleName = rule.Name
+		}
+
+		p.accessLog.Log(logging.AccessLogEntry{
+			Timestamp:   time.Now(),
+			CacheStatus: cacheStatus,
+			Status:      statusCode,
+			Method:      r.Method,
+			Size:        responseSize,
+			Duration:    duration.Milliseconds(),
+			URL:         fullURL,
+			ContentType: contentType,
+			Rule:        ruleName,
+		})
 	}
 }
 
-// getCacheKey creates a normalized cache key from a request's URL.
-func getCacheKey(r *http.Request) string {
-	u := *r.URL
-	u.Fragment = ""
-
-	q := u.Query()
-	if len(q) > 0 {
-		keys := make([]string, 0, len(q))
-		for k := range q {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
+// CacheKeyForURL returns the cache key a GET request for rawURL would be stored under.
+func (p *Proxy) CacheKeyForURL(rawURL string) (string, error) {
+	return p.CacheKeyForRequest(rawURL, nil)
+}
 
-		newValues := make(url.Values)
-		for _, k := range keys {
-			newValues[k] = q[k]
+// CacheKeyForRequest returns the cache key a GET request for rawURL with the
+// given request headers would be stored under.
+func (p *Proxy) CacheKeyForRequest(rawURL string, header http.Header) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return "", fmt.Errorf("unsupported URL scheme: %q", req.URL.Scheme)
+	}
+	if req.URL.Host == "" {
+		return "", fmt.Errorf("URL has no host: %q", rawURL)
+	}
+	for name, values := range header {
+		for _, v := range values {
+			req.Header.Add(name, v)
 		}
-		u.RawQuery = newValues.Encode()
 	}
-
-	return u.String()
+	return p.cacheKey(p.takeControls(req)), nil
 }
 
 // isErrorStatusCode returns true if the status code is 4xx or 5xx
@@ -185,11 +236,19 @@ func isErrorStatusCode(statusCode int) bool {
 	return statusCode >= 400 && statusCode <= 599
 }
 
-// getPostCacheKey creates a cache key for a POST request.
-// The key is a combination of the URL (with or without query string) and a hash of the request body.
-func (p *Proxy) getPostCacheKey(r *http.Request, body []byte) string {
-	// Start with the base URL, path only
-	keyURL := r.URL.Scheme + "://" + r.URL.Host + r.URL.Path
+// bodyCacheKey creates a cache key for a request cached by its body, such as a POST.
+// The key is a combination of the URL (with or without query string), the method for
+// methods other than POST, and a hash of the request body, canonicalized as configured
+// in [cache.post_cache.canonicalize].
+func (p *Proxy) bodyCacheKey(r *http.Request, body []byte) string {
+	// Start with the base URL, path only. The host follows the same key rules
+	// as GET requests, so the :443 a request read off a TLS connection takes
+	// from its CONNECT target is dropped from the key by default.
+	host := r.URL.Host
+	if rules := p.keyRulesFor(r); rules.normalizeHost || rules.stripDefaultPort {
+		host = normalizeHostPort(r.URL.Scheme, host, rules.normalizeHost, rules.stripDefaultPort)
+	}
+	keyURL := r.URL.Scheme + "://" + host + r.URL.Path
 
 	// Include query string if configured
 	if p.config.Cache.PostCache.IncludeQueryString && r.URL.RawQuery != "" {
@@ -198,48 +257,105 @@ func (p *Proxy) getPostCacheKey(r *http.Request, body []byte) string {
 
 	// Add the hash of the body
 	hasher := sha256.New()
-	hasher.Write(body)
+	hasher.Write(p.canonicalPostBody(r, body))
 	bodyHash := hex.EncodeToString(hasher.Sum(nil))
 
-	return keyURL + ":" + bodyHash
+	key := keyURL + ":" + bodyHash
+	if r.Method != http.MethodPost {
+		key = keyURL + ":" + r.Method + ":" + bodyHash
+	}
+	if extra := controlsFrom(r).keyExtra; extra != "" {
+		key += "#" + keyExtraPart(extra)
+	}
+	return key
 }
 
-// shouldCacheRequest determines if a request should be cached based on HTTP method.
+// shouldCacheRequest determines if a request should be cached based on HTTP
+// method and any matching cache rule.
 func (p *Proxy) shouldCacheRequest(r *http.Request) bool {
-	// Only cache GET requests by default
-	// Other methods can be enabled through specific configuration
-	return r.Method == http.MethodGet
+	return p.requestNotCacheable(r) == ""
 }
 
-// shouldCacheResponse determines if a response should be cached.
-func (p *Proxy) shouldCacheResponse(resp *http.Response) bool {
-	contentType := resp.Header.Get("Content-Type")
-	isCacheableType := false
-	for _, t := range p.config.Cache.CacheableTypes {
-		if strings.HasPrefix(contentType, t) {
-			isCacheableType = true
-			break
-		}
+// canServeFromCache reports whether r may be answered from the cache. HEAD
+// requests are answered from cached GET responses, but responses to them are
+// never stored, since they have no body.
+func (p *Proxy) canServeFromCache(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		rule := p.ruleFor(r)
+		return p.config.Cache.MethodCacheable(http.MethodGet) && (rule == nil || !rule.NeverCache)
 	}
-	if !isCacheableType {
-		p.logger.Debug("skipping cache: non-cacheable content type", "contentType", contentType)
-		return false
+	return p.shouldCacheRequest(r)
+}
+
+// requestNotCacheable returns why responses to r are never cached by URL, or
+// "" if they may be. Only GET responses are keyed by URL; other cacheable
+// methods are keyed by their body, see bodyCacheable.
+func (p *Proxy) requestNotCacheable(r *http.Request) string {
+	if r.Method != http.MethodGet || !p.config.Cache.MethodCacheable(http.MethodGet) {
+		return "method"
+	}
+	if rule := p.ruleFor(r); rule != nil && rule.NeverCache {
+		return "never-cache"
+	}
+	return ""
+}
+
+// shouldCacheResponse determines if the response to r should be cached. body
+// is only used to sniff the content type when the response does not declare one.
+// A cache rule matching r may override the cacheable types and no-cache handling.
+func (p *Proxy) shouldCacheResponse(r *http.Request, resp *http.Response, body []byte) bool {
+	return p.responseNotCacheable(r, resp, body) == ""
+}
+
+// responseNotCacheable returns why the response to r must not be cached, or
+// "" if it may be. The reason is reported in the X-Cache-Reason debug header.
+func (p *Proxy) responseNotCacheable(r *http.Request, resp *http.Response, body []byte) string {
+	rule := p.ruleFor(r)
+	if rule != nil && rule.NeverCache {
+		p.logger.Debug("skipping cache: never_cache rule", "rule", rule.Name)
+		return "never-cache"
+	}
+	if p.clientNoStore(r) {
+		p.logger.Debug("skipping cache: client sent no-store")
+		return "no-store"
+	}
+	if ctl := controlsFrom(r); ctl.bypass || ctl.noStore {
+		p.logger.Debug("skipping cache: disabled by request header")
+		return "request-header"
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		p.logger.Debug("skipping cache: partial content")
+		return "partial"
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		p.logger.Debug("skipping cache: not modified")
+		return "not-modified"
+	}
+	if ttl, ok := p.config.Cache.GetStatusTTL(resp.StatusCode); ok && ttl == 0 {
+		p.logger.Debug("skipping cache: status_ttl is zero", "statusCode", resp.StatusCode)
+		return "status"
+	}
+
+	mediaType := p.responseMediaType(resp, body)
+	if !p.isCacheableType(rule, mediaType) {
+		p.logger.Debug("skipping cache: non-cacheable content type", "contentType", mediaType)
+		return "content-type"
 	}
 
-	if !p.config.Cache.IgnoreNoCache {
+	if !p.ignoreNoCache(rule) {
 		cacheControl := resp.Header.Get("Cache-Control")
 		if strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store") {
 			p.logger.Debug("skipping cache: cache-control header", "value", cacheControl)
-			return false
+			return "cache-control"
 		}
 		pragma := resp.Header.Get("Pragma")
 		if pragma == "no-cache" {
 			p.logger.Debug("skipping cache: pragma header", "value", pragma)
-			return false
+			return "pragma"
 		}
 	}
 
-	return true
+	return ""
 }
 
 // ServeHTTP is the main handler for all incoming proxy requests.
@@ -252,6 +368,18 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := p.handleRequest(w, r, r); err != nil {
+		// Abort the connection so the client sees the truncation
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// handleRequest serves a proxied request. conn is the request that opened the
+// client's connection, which selects the namespace: r itself for plain HTTP,
+// or the CONNECT request for the streams of an intercepted HTTP/2 connection.
+// It returns an error if the response was truncated after it was started, so
+// the caller can abort the client's connection.
+func (p *Proxy) handleRequest(w http.ResponseWriter, r, conn *http.Request) error {
 	startTime := time.Now()
 
 	// Wrap response writer for access logging
@@ -260,48 +388,66 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	p.logger.Info("http request", "method", r.Method, "url", r.URL)
 	p.logger.Debug("http request details", "headers", r.Header, "contentLength", r.ContentLength)
 
-	if r.Method == http.MethodPost && p.config.Cache.PostCache.Enable {
-		p.handlePostRequest(crw, r)
-		// Log access for POST requests handled separately
+	namespace, c, err := p.resolveNamespace(r, conn)
+	if err != nil {
+		http.Error(crw, err.Error(), http.StatusBadRequest)
+		p.logAccess(startTime, r, http.StatusBadRequest, crw.Size(), "", "text/plain")
+		return nil
+	}
+	p.logger.Debug("cache namespace selected", "namespace", namespace)
+	r = p.takeControls(r)
+
+	if isUpgrade(r) {
+		p.handleUpgrade(w, r, hijackerFor(w))
+		return nil
+	}
+
+	if rule := p.ruleFor(r); rule != nil {
+		p.logger.Debug("cache rule matched", "rule", rule.Name)
+	}
+
+	if p.bodyCacheable(r) {
+		err := p.handleBodyRequest(crw, r, namespace, c)
+		// Log access for requests cached by body
 		contentType := crw.Header().Get("Content-Type")
 		cacheStatus := crw.Header().Get("X-Cache")
 		if cacheStatus == "" {
-			cacheStatus = "" // POST requests may or may not be cached
+			cacheStatus = "" // Requests cached by body may or may not be cached
 		}
 		p.logAccess(startTime, r, crw.StatusCode(), crw.Size(), cacheStatus, contentType)
-		return
+		return err
 	}
 
 	// Only check cache for cacheable request methods
 	var cacheKey string
 	var fromCache bool
-	if p.shouldCacheRequest(r) {
-		cacheKey = getCacheKey(r)
-		if entry, ok := p.cache.Get(cacheKey); ok {
+	if p.canServeFromCache(r) {
+		cacheKey = p.cacheKey(r)
+		if entry, ok := p.lookupEntry(c, r, cacheKey); ok {
 			p.logger.Info("cache hit", "key", cacheKey)
 			p.logger.Debug("serving cached response", "statusCode", entry.StatusCode, "bodySize", len(entry.Body))
-			crw.Header().Set("X-Cache", "HIT")
-			for key, values := range entry.Headers {
-				for _, value := range values {
-					crw.Header().Add(key, value)
-				}
-			}
-			crw.WriteHeader(entry.StatusCode)
-			crw.Write(entry.Body)
+			p.writeEntry(crw, r, c, cacheKey, entry, "HIT", debugInfo{key: cacheKey, hit: true, entry: entry})
+			p.maybeRefresh(namespace, cacheKey, entry, r)
 
 			// Log access for cached response
 			contentType := crw.Header().Get("Content-Type")
 			p.logAccess(startTime, r, crw.StatusCode(), crw.Size(), "HIT", contentType)
-			return
+			return nil
+		}
+		if p.onlyIfCached(r) {
+			p.logger.Debug("cache miss for only-if-cached request", "key", cacheKey)
+			http.Error(crw, "Not cached", http.StatusGatewayTimeout)
+			p.logAccess(startTime, r, http.StatusGatewayTimeout, crw.Size(), "MISS", "text/plain")
+			return nil
 		}
 		fromCache = false
 	} else {
 		p.logger.Debug("request method not cacheable", "method", r.Method)
 	}
 
-	if fromCache == false && p.shouldCacheRequest(r) {
+	if fromCache == false && p.canServeFromCache(r) {
 		p.logger.Info("cache miss", "key", cacheKey)
-	} else if !p.shouldCacheRequest(r) {
+	} else if !p.canServeFromCache(r) {
 		p.logger.Debug("forwarding non-cacheable request to upstream", "method", r.Method, "url", r.URL.String())
 	}
 
@@ -309,75 +455,66 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	r.Header.Del("Proxy-Connection")
 	r.Header.Del("Proxy-Authorization")
 
+	// Fetch the full representation in a coding the cache can decode; ranges,
+	// conditional requests and encodings are served from it below
+	savedRange := p.takeRangeHeaders(r)
+	savedValidators := p.takeValidators(r)
+	savedEncoding := p.takeAcceptEncoding(r)
+
+	upstreamStart := time.Now()
 	resp, err := p.transport.RoundTrip(r)
+	restoreHeaders(r, savedRange)
+	restoreHeaders(r, savedValidators)
+	restoreHeaders(r, savedEncoding)
 	if err != nil {
 		p.logger.Error("failed to forward http request", "error", err)
 		p.logger.Debug("upstream request failed", "url", r.URL.String(), "error", err)
 		http.Error(crw, err.Error(), http.StatusServiceUnavailable)
 		// Log access for error response
 		p.logAccess(startTime, r, http.StatusServiceUnavailable, crw.Size(), "", "text/plain")
-		return
+		return nil
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	// Set cache header based on whether request method is cacheable
+	var cacheStatus string
+	if p.shouldCacheRequest(r) {
+		cacheStatus = missStatus(r)
+	}
+	u := upstreamResponse{
+		Response:    resp,
+		cache:       c,
+		namespace:   namespace,
+		key:         cacheKey,
+		reason:      p.requestNotCacheable(r),
+		cacheStatus: cacheStatus,
+		limit:       entryLimit(c),
+		start:       upstreamStart,
+		fullBody:    savedRange != nil || savedValidators != nil,
+	}
+	if err := p.writeUpstream(crw, r, u); err != nil {
 		p.logger.Error("failed to read http response body", "error", err)
+		if errors.Is(err, errResponseTruncated) {
+			p.logAccess(startTime, r, crw.StatusCode(), crw.Size(), cacheStatus, crw.Header().Get("Content-Type"))
+			return err
+		}
 		http.Error(crw, err.Error(), http.StatusServiceUnavailable)
 		// Log access for error response
 		p.logAccess(startTime, r, http.StatusServiceUnavailable, crw.Size(), "", "text/plain")
-		return
-	}
-
-	// Only cache responses for cacheable request methods
-	if p.shouldCacheRequest(r) && p.shouldCacheResponse(resp) {
-		entry := cache.CacheEntry{
-			StatusCode: resp.StatusCode,
-			Headers:    resp.Header,
-			Body:       body,
-		}
-
-		// Use negative TTL for error status codes (4xx, 5xx)
-		if isErrorStatusCode(resp.StatusCode) {
-			negativeTTL := p.config.Cache.GetNegativeTTL()
-			p.cache.SetWithTTL(cacheKey, entry, negativeTTL)
-			p.logger.Info("response cached with negative TTL", "key", cacheKey, "ttl", negativeTTL)
-			p.logger.Debug("cached error response details", "statusCode", resp.StatusCode, "contentType", resp.Header.Get("Content-Type"), "bodySize", len(body), "negativeTTL", negativeTTL)
-		} else {
-			p.cache.Set(cacheKey, entry)
-			p.logger.Info("response cached", "key", cacheKey)
-			p.logger.Debug("cached response details", "statusCode", resp.StatusCode, "contentType", resp.Header.Get("Content-Type"), "bodySize", len(body))
-		}
-	} else if p.shouldCacheRequest(r) {
-		p.logger.Debug("response not cached", "key", cacheKey, "statusCode", resp.StatusCode)
-	} else {
-		p.logger.Debug("response not cached - method not cacheable", "method", r.Method, "statusCode", resp.StatusCode)
-	}
-
-	for key, values := range resp.Header {
-		for _, value := range values {
-			crw.Header().Add(key, value)
-		}
-	}
-
-	// Set cache header based on whether request method is cacheable
-	var cacheStatus string
-	if p.shouldCacheRequest(r) {
-		crw.Header().Set("X-Cache", "MISS")
-		cacheStatus = "MISS"
-	} else {
-		cacheStatus = "" // Non-cacheable requests don't have cache status
+		return nil
 	}
 
-	crw.WriteHeader(resp.StatusCode)
-	crw.Write(body)
-
 	// Log access for successful response
 	contentType := crw.Header().Get("Content-Type")
 	p.logAccess(startTime, r, crw.StatusCode(), crw.Size(), cacheStatus, contentType)
+	return nil
 }
 
-func (p *Proxy) handlePostRequest(w http.ResponseWriter, r *http.Request) {
+// handleBodyRequest serves a request that is cached by its body, such as a
+// POST, over either HTTP or a MITM HTTPS connection. It returns an error
+// wrapping errResponseTruncated if the response was cut short after its
+// headers were sent.
+func (p *Proxy) handleBodyRequest(w http.ResponseWriter, r *http.Request, namespace string, c *cache.MemoryCache) error {
 	// Enforce request body size limit
 	maxSize := int64(p.config.Cache.PostCache.MaxRequestBodySizeMB) * 1024 * 1024
 	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
@@ -385,87 +522,78 @@ func (p *Proxy) handlePostRequest(w http.ResponseWriter, r *http.Request) {
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		if err.Error() == "http: request body too large" {
-			p.logger.Warn("POST request body too large", "limit_bytes", maxSize, "url", r.URL.String())
+			p.logger.Warn("request body too large", "method", r.Method, "limit_bytes", maxSize, "url", r.URL.String())
 			http.Error(w, "Request Body Too Large", http.StatusRequestEntityTooLarge)
 		} else {
-			p.logger.Error("failed to read POST request body", "error", err)
+			p.logger.Error("failed to read request body", "method", r.Method, "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
-		return
+		return nil
 	}
 	// Restore the body so it can be sent to the upstream server
 	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 	// Check cache
-	cacheKey := p.getPostCacheKey(r, bodyBytes)
-	if entry, ok := p.cache.Get(cacheKey); ok {
-		p.logger.Info("cache hit (POST)", "key", cacheKey)
-		p.logger.Debug("serving cached POST response", "statusCode", entry.StatusCode, "bodySize", len(entry.Body))
-		w.Header().Set("X-Cache", "HIT")
-		for key, values := range entry.Headers {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
-		}
-		w.WriteHeader(entry.StatusCode)
-		w.Write(entry.Body)
-		return
+	cacheKey := p.bodyCacheKey(r, bodyBytes)
+	if entry, ok := p.lookupEntry(c, r, cacheKey); ok {
+		p.logger.Info("cache hit", "key", cacheKey, "method", r.Method)
+		p.logger.Debug("serving cached response", "statusCode", entry.StatusCode, "bodySize", len(entry.Body))
+		p.writeEntry(w, r, c, cacheKey, entry, "HIT", debugInfo{key: cacheKey, hit: true, entry: entry})
+		return nil
+	}
+	if p.onlyIfCached(r) {
+		p.logger.Debug("cache miss for only-if-cached request", "key", cacheKey)
+		w.Header().Set("X-Cache", "MISS")
+		http.Error(w, "Not cached", http.StatusGatewayTimeout)
+		return nil
 	}
 
-	p.logger.Info("cache miss (POST)", "key", cacheKey)
+	p.logger.Info("cache miss", "key", cacheKey, "method", r.Method)
 
 	r.RequestURI = ""
 	r.Header.Del("Proxy-Connection")
 	r.Header.Del("Proxy-Authorization")
 
+	savedEncoding := p.takeAcceptEncoding(r)
+	upstreamStart := time.Now()
 	resp, err := p.transport.RoundTrip(r)
+	restoreHeaders(r, savedEncoding)
 	if err != nil {
 		p.logger.Error("failed to forward http request", "error", err)
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-		return
+		return nil
 	}
 	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.logger.Error("failed to read http response body", "error", err)
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-		return
+	// Cap the cached response at the body request response size limit
+	limit := int64(p.config.Cache.PostCache.MaxResponseBodySizeMB) * 1024 * 1024
+	if cacheLimit := entryLimit(c); cacheLimit > 0 && cacheLimit < limit {
+		limit = cacheLimit
 	}
-
-	// Check response body size before caching
-	maxRespSize := int64(p.config.Cache.PostCache.MaxResponseBodySizeMB) * 1024 * 1024
-	if int64(len(respBody)) > maxRespSize {
-		p.logger.Warn("POST response body too large to cache", "limit_bytes", maxRespSize, "actual_bytes", len(respBody), "url", r.URL.String())
-	} else if p.shouldCacheResponse(resp) {
-		entry := cache.CacheEntry{
-			StatusCode: resp.StatusCode,
-			Headers:    resp.Header,
-			Body:       respBody,
-		}
-
-		if isErrorStatusCode(resp.StatusCode) {
-			negativeTTL := p.config.Cache.GetNegativeTTL()
-			p.cache.SetWithTTL(cacheKey, entry, negativeTTL)
-			p.logger.Info("POST response cached with negative TTL", "key", cacheKey, "ttl", negativeTTL)
-		} else {
-			p.cache.Set(cacheKey, entry)
-			p.logger.Info("POST response cached", "key", cacheKey)
-		}
+	u := upstreamResponse{
+		Response:    resp,
+		cache:       c,
+		namespace:   namespace,
+		key:         cacheKey,
+		cacheStatus: missStatus(r),
+		limit:       limit,
+		start:       upstreamStart,
 	}
-
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	if err := p.writeUpstream(w, r, u); err != nil {
+		p.logger.Error("failed to read http response body", "error", err)
+		if errors.Is(err, errResponseTruncated) {
+			return err
 		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 	}
-	w.Header().Set("X-Cache", "MISS")
-	w.WriteHeader(resp.StatusCode)
-	w.Write(respBody)
+	return nil
 }
 
 func (p *Proxy) handleHTTPS(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
+	if !p.shouldIntercept(r) {
+		p.handleTunnel(w, r)
+		return
+	}
 
 	p.logger.Info("https request", "host", r.Host)
 	p.logger.Debug("https connect request details", "method", r.Method, "host", r.Host, "userAgent", r.Header.Get("User-Agent"))
@@ -499,136 +627,149 @@ func (p *Proxy) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tlsConfig := &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+	if p.config.Server.EnableHTTP2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
 	tlsConn := tls.Server(clientConn, tlsConfig)
 	defer tlsConn.Close()
 
-	reader := bufio.NewReader(tlsConn)
-	req, err := http.ReadRequest(reader)
-	if err != nil {
-		if err != io.EOF {
-			p.logger.Error("failed to read https request", "error", err)
-		}
-		return
-	}
+	p.serveMITMConn(tlsConn, r)
+}
+
+// serveMITMRequest answers one request read off the decrypted connection of
+// the CONNECT request r. It reports whether the response was written in full,
+// so the connection can carry another request.
+func (p *Proxy) serveMITMRequest(tlsConn net.Conn, r, req *http.Request) bool {
+	startTime := time.Now()
 
 	req.URL.Scheme = "https"
 	req.URL.Host = r.Host
 
+	namespace, c, err := p.resolveNamespace(req, r)
+	if err != nil {
+		size, werr := writeConnError(tlsConn, req, err.Error(), http.StatusBadRequest)
+		p.logAccess(startTime, req, http.StatusBadRequest, size, "", "text/plain")
+		return werr == nil
+	}
+	p.logger.Debug("cache namespace selected", "namespace", namespace)
+	req = p.takeControls(req)
+
+	if p.bodyCacheable(req) {
+		cw := newConnResponseWriter(tlsConn, req)
+		// A truncated response is left unfinished so the client sees it
+		err := p.handleBodyRequest(cw, req, namespace, c)
+		if err == nil {
+			if err = cw.finish(); err != nil {
+				p.logger.Error("failed to write https response", "error", err)
+			}
+		}
+		p.logAccess(startTime, req, cw.statusCode, cw.size, cw.Header().Get("X-Cache"), cw.Header().Get("Content-Type"))
+		return err == nil
+	}
+
 	// Only check cache for cacheable request methods
 	var cacheKey string
 	var fromCache bool
-	if p.shouldCacheRequest(req) {
-		cacheKey = getCacheKey(req)
-		if entry, ok := p.cache.Get(cacheKey); ok {
+	if p.canServeFromCache(req) {
+		cacheKey = p.cacheKey(req)
+		if entry, ok := p.lookupEntry(c, req, cacheKey); ok {
 			p.logger.Info("cache hit (https)", "key", cacheKey)
 			p.logger.Debug("serving cached https response", "statusCode", entry.StatusCode, "bodySize", len(entry.Body))
-			entry.Headers.Set("X-Cache", "HIT")
-			cachedResp := http.Response{
-				StatusCode: entry.StatusCode,
-				Header:     entry.Headers,
-				Body:       io.NopCloser(bytes.NewReader(entry.Body)),
-			}
-			if err := cachedResp.Write(tlsConn); err != nil {
+			cw := newConnResponseWriter(tlsConn, req)
+			p.writeEntry(cw, req, c, cacheKey, entry, "HIT", debugInfo{key: cacheKey, hit: true, entry: entry})
+			err := cw.finish()
+			if err != nil {
 				p.logger.Error("failed to write cached https response", "error", err)
 				// Log access for error
 				p.logAccess(startTime, req, http.StatusInternalServerError, 0, "HIT", "")
 			} else {
 				// Log access for successful cached response
 				contentType := entry.Headers.Get("Content-Type")
-				p.logAccess(startTime, req, entry.StatusCode, int64(len(entry.Body)), "HIT", contentType)
+				p.logAccess(startTime, req, cw.statusCode, cw.size, "HIT", contentType)
 			}
-			return
+			p.maybeRefresh(namespace, cacheKey, entry, req)
+			return err == nil
+		}
+		if p.onlyIfCached(req) {
+			p.logger.Debug("cache miss for only-if-cached https request", "key", cacheKey)
+			size, err := writeConnError(tlsConn, req, "Not cached", http.StatusGatewayTimeout)
+			p.logAccess(startTime, req, http.StatusGatewayTimeout, size, "MISS", "text/plain")
+			return err == nil
 		}
 		fromCache = false
 	} else {
 		p.logger.Debug("https request method not cacheable", "method", req.Method)
 	}
 
-	if fromCache == false && p.shouldCacheRequest(req) {
+	if fromCache == false && p.canServeFromCache(req) {
 		p.logger.Info("cache miss (https)", "key", cacheKey)
-	} else if !p.shouldCacheRequest(req) {
+	} else if !p.canServeFromCache(req) {
 		p.logger.Debug("forwarding non-cacheable https request to upstream", "method", req.Method, "url", req.URL.String())
 	}
 
+	// Fetch the full representation in a coding the cache can decode; ranges,
+	// conditional requests and encodings are served from it below
+	savedRange := p.takeRangeHeaders(req)
+	savedValidators := p.takeValidators(req)
+	savedEncoding := p.takeAcceptEncoding(req)
+
+	upstreamStart := time.Now()
 	resp, err := p.transport.RoundTrip(req)
+	restoreHeaders(req, savedRange)
+	restoreHeaders(req, savedValidators)
+	restoreHeaders(req, savedEncoding)
 	if err != nil {
 		p.logger.Error("failed to forward https request", "error", err)
 		p.logger.Debug("upstream https request failed", "url", req.URL.String(), "error", err)
-		errorResponse := &http.Response{
-			StatusCode: http.StatusBadGateway,
-			Proto:      "HTTP/1.1",
-			ProtoMajor: 1,
-			ProtoMinor: 1,
-			Body:       io.NopCloser(bytes.NewBufferString("Bad Gateway\n")),
-		}
-		errorResponse.Write(tlsConn)
+		size, err := writeConnError(tlsConn, req, "Bad Gateway", http.StatusBadGateway)
 		// Log access for error response
-		p.logAccess(startTime, req, http.StatusBadGateway, 12, "", "text/plain") // "Bad Gateway\n" is 12 bytes
-		return
+		p.logAccess(startTime, req, http.StatusBadGateway, size, "", "text/plain")
+		return err == nil
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.logger.Error("failed to read https response body", "error", err)
-		// Log access for error
-		p.logAccess(startTime, req, http.StatusInternalServerError, 0, "", "")
-		return
-	}
-
-	// Only cache responses for cacheable request methods
-	if p.shouldCacheRequest(req) && p.shouldCacheResponse(resp) {
-		entry := cache.CacheEntry{
-			StatusCode: resp.StatusCode,
-			Headers:    resp.Header,
-			Body:       body,
-		}
-
-		// Use negative TTL for error status codes (4xx, 5xx)
-		if isErrorStatusCode(resp.StatusCode) {
-			negativeTTL := p.config.Cache.GetNegativeTTL()
-			p.cache.SetWithTTL(cacheKey, entry, negativeTTL)
-			p.logger.Info("response cached with negative TTL (https)", "key", cacheKey, "ttl", negativeTTL)
-			p.logger.Debug("cached error response details (https)", "statusCode", resp.StatusCode, "contentType", resp.Header.Get("Content-Type"), "bodySize", len(body), "negativeTTL", negativeTTL)
-		} else {
-			p.cache.Set(cacheKey, entry)
-			p.logger.Info("response cached (https)", "key", cacheKey)
-			p.logger.Debug("cached response details (https)", "statusCode", resp.StatusCode, "contentType", resp.Header.Get("Content-Type"), "bodySize", len(body))
-		}
-	} else if p.shouldCacheRequest(req) {
-		p.logger.Debug("https response not cached", "key", cacheKey, "statusCode", resp.StatusCode)
-	} else {
-		p.logger.Debug("https response not cached - method not cacheable", "method", req.Method, "statusCode", resp.StatusCode)
-	}
-
 	// Set cache header based on whether request method is cacheable
 	var cacheStatus string
 	if p.shouldCacheRequest(req) {
-		resp.Header.Set("X-Cache", "MISS")
-		cacheStatus = "MISS"
-	} else {
-		cacheStatus = "" // Non-cacheable requests don't have cache status
+		cacheStatus = missStatus(req)
 	}
-
-	newResp := http.Response{
-		StatusCode:    resp.StatusCode,
-		Proto:         resp.Proto,
-		ProtoMajor:    resp.ProtoMajor,
-		ProtoMinor:    resp.ProtoMinor,
-		Header:        resp.Header,
-		Body:          io.NopCloser(bytes.NewReader(body)),
-		ContentLength: int64(len(body)),
+	u := upstreamResponse{
+		Response:    resp,
+		cache:       c,
+		namespace:   namespace,
+		key:         cacheKey,
+		reason:      p.requestNotCacheable(req),
+		cacheStatus: cacheStatus,
+		limit:       entryLimit(c),
+		start:       upstreamStart,
+		fullBody:    savedRange != nil || savedValidators != nil,
+	}
+	cw := newConnResponseWriter(tlsConn, req)
+	if err := p.writeUpstream(cw, req, u); err != nil {
+		p.logger.Error("failed to read https response body", "error", err)
+		if errors.Is(err, errResponseTruncated) {
+			// Closing the connection without finishing the response lets the
+			// client see the truncation
+			p.logAccess(startTime, req, cw.statusCode, cw.size, cacheStatus, cw.Header().Get("Content-Type"))
+			return false
+		}
+		http.Error(cw, "Bad Gateway", http.StatusBadGateway)
+		err := cw.finish()
+		// Log access for error response
+		p.logAccess(startTime, req, http.StatusBadGateway, cw.size, "", "text/plain")
+		return err == nil
 	}
 
-	if err := newResp.Write(tlsConn); err != nil {
+	if err := cw.finish(); err != nil {
 		p.logger.Error("failed to write https response", "error", err)
 		// Log access for write error
 		p.logAccess(startTime, req, http.StatusInternalServerError, 0, cacheStatus, "")
-	} else {
-		// Log access for successful response
-		contentType := resp.Header.Get("Content-Type")
-		p.logAccess(startTime, req, resp.StatusCode, int64(len(body)), cacheStatus, contentType)
+		return false
 	}
+	// Log access for successful response
+	contentType := resp.Header.Get("Content-Type")
+	p.logAccess(startTime, req, cw.statusCode, cw.size, cacheStatus, contentType)
+	return true
 }
 
 // evictOldestCert removes the least recently used certificate.
@@ -722,5 +863,11 @@ func (p *Proxy) Start(addr string) error {
 // Shutdown gracefully shuts down the proxy server.
 func (p *Proxy) Shutdown(ctx context.Context) error {
 	p.logger.Info("shutting down proxy server")
+	if err := p.shutdownListeners(ctx); err != nil {
+		p.logger.Error("namespace listener shutdown failed", "error", err)
+	}
+	if p.server == nil {
+		return nil
+	}
 	return p.server.Shutdown(ctx)
 }
@@ -3,6 +3,7 @@ package proxy
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,14 +11,17 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gbmerrall/gocache/internal/cache"
 	"github.com/gbmerrall/gocache/internal/cert"
 	"github.com/gbmerrall/gocache/internal/config"
+	"github.com/gbmerrall/gocache/internal/logging"
 )
 
 func setupProxyTest(t *testing.T) (*httptest.Server, *http.Client, *cache.MemoryCache, func()) {
@@ -158,6 +162,27 @@ func setupTestProxy(t *testing.T) (*Proxy, func()) {
 	}
 }
 
+// newMITMClient serves p on a test listener and returns a client that sends
+// HTTPS requests through it, trusting the proxy's CA. Upstream TLS
+// certificates are not verified by the proxy.
+func newMITMClient(t *testing.T, p *Proxy) *http.Client {
+	t.Helper()
+	proxyServer := httptest.NewServer(p)
+	t.Cleanup(proxyServer.Close)
+
+	p.SetTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})
+
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(p.GetCA())
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+}
+
 func TestNewProxy(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "gocache-test-proxy")
 	if err != nil {
@@ -245,7 +270,7 @@ func TestSetTransport(t *testing.T) {
 }
 
 func TestGetCacheKey(t *testing.T) {
-	// Test getCacheKey function directly
+	// Test buildCacheKey function directly
 	tests := []struct {
 		name     string
 		url      string
@@ -269,9 +294,9 @@ func TestGetCacheKey(t *testing.T) {
 				t.Fatalf("failed to create request: %v", err)
 			}
 
-			key := getCacheKey(req)
+			key := buildCacheKey(req, keyRules{})
 			if key != tt.expected {
-				t.Errorf("getCacheKey(%s) = %s, want %s", tt.url, key, tt.expected)
+				t.Errorf("buildCacheKey(%s) = %s, want %s", tt.url, key, tt.expected)
 			}
 		})
 	}
@@ -314,7 +339,7 @@ func TestShouldCacheResponse(t *testing.T) {
 				resp.Header.Set("Content-Type", tt.contentType)
 			}
 
-			result := proxy.shouldCacheResponse(resp)
+			result := proxy.shouldCacheResponse(httptest.NewRequest(http.MethodGet, "http://example.com/", nil), resp, nil)
 			if result != tt.expectedResult {
 				t.Errorf("shouldCacheResponse(%d, %s) = %v, want %v",
 					tt.statusCode, tt.contentType, result, tt.expectedResult)
@@ -344,7 +369,7 @@ func TestProxyCaching(t *testing.T) {
 	}
 
 	// Check if response was cached
-	cacheKey := getCacheKey(req1)
+	cacheKey := proxy.cacheKey(req1)
 	cached, found := proxy.cache.Get(cacheKey)
 	if !found {
 		t.Error("expected response to be cached")
@@ -390,7 +415,7 @@ func TestProxyNonCacheable(t *testing.T) {
 	}
 
 	// Check that response was NOT cached
-	cacheKey := getCacheKey(req)
+	cacheKey := proxy.cacheKey(req)
 	_, found := proxy.cache.Get(cacheKey)
 	if found {
 		t.Error("expected response NOT to be cached")
@@ -466,7 +491,7 @@ func TestProxyRequestMethods(t *testing.T) {
 
 			if method == "GET" {
 				// Check if GET was cached
-				cacheKey := getCacheKey(req)
+				cacheKey := proxy.cacheKey(req)
 				_, found := proxy.cache.Get(cacheKey)
 				if !found {
 					t.Error("expected GET request to be cached")
@@ -631,7 +656,7 @@ func TestNegativeTTL(t *testing.T) {
 		}
 
 		// Check that response was cached
-		cacheKey := getCacheKey(req1)
+		cacheKey := p.cacheKey(req1)
 		cached, found := c.Get(cacheKey)
 		if !found {
 			t.Error("expected 404 response to be cached")
@@ -713,7 +738,7 @@ func TestNegativeTTL(t *testing.T) {
 		}
 
 		// Verify still cached
-		cacheKey := getCacheKey(req1)
+		cacheKey := p.cacheKey(req1)
 		_, found := c.Get(cacheKey)
 		if !found {
 			t.Error("expected 200 response to still be cached")
@@ -949,6 +974,99 @@ func TestProxyPostCaching(t *testing.T) {
 	})
 }
 
+func TestProxyPostCachingHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.config.Cache.PostCache.Enable = true
+	proxy.config.Cache.PostCache.MaxRequestBodySizeMB = 1
+	proxy.config.Cache.PostCache.MaxResponseBodySizeMB = 1
+
+	var upstreamRequests atomic.Int32
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/large" {
+			w.Write(make([]byte, 2*1024*1024)) // 2MB response
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := logging.NewAccessLogger(logging.AccessLoggerConfig{
+		Format:     logging.FormatJSON,
+		LogFile:    logFile,
+		BufferSize: 10,
+	})
+	if err != nil {
+		t.Fatalf("failed to create access logger: %v", err)
+	}
+	proxy.accessLog = accessLog
+
+	client := newMITMClient(t, proxy)
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := client.Post(upstream.URL+path, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, want := range []string{"MISS", "HIT"} {
+		if resp := post("/api", "request body"); resp.Header.Get("X-Cache") != want {
+			t.Errorf("got X-Cache %q, want %s", resp.Header.Get("X-Cache"), want)
+		}
+	}
+	if resp := post("/api", "other body"); resp.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("got X-Cache %q for a different body, want MISS", resp.Header.Get("X-Cache"))
+	}
+	if n := upstreamRequests.Load(); n != 2 {
+		t.Errorf("got %d upstream requests, want 2", n)
+	}
+
+	if resp := post("/api", string(make([]byte, 2*1024*1024))); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d for a 2MB request body, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+
+	post("/large", "body")
+	if resp := post("/large", "body"); resp.Header.Get("X-Cache") == "HIT" {
+		t.Error("expected a response over the size limit not to be cached")
+	}
+
+	accessLog.Close()
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("failed to read access log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	want := []struct {
+		cacheStatus string
+		status      int
+	}{{"MISS", 200}, {"HIT", 200}, {"MISS", 200}, {"", 413}, {"MISS", 200}, {"MISS", 200}}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d access log entries, got %d", len(want), len(lines))
+	}
+	for i, w := range want {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("failed to parse access log entry: %v", err)
+		}
+		if entry["method"] != "POST" || entry["cache_status"] != w.cacheStatus || entry["status"] != float64(w.status) {
+			t.Errorf("entry %d: got %v %v %v, want POST %s %d", i, entry["method"], entry["cache_status"], entry["status"], w.cacheStatus, w.status)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "https://Example.com:443/api", nil)
+	if key := proxy.bodyCacheKey(req, []byte("body")); !strings.HasPrefix(key, "https://example.com/api:") {
+		t.Errorf("got key %q, want the host normalized as for GET requests", key)
+	}
+}
+
 func TestCertCacheLRUDataStructures(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "gocache-test-lru")
 	if err != nil {
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rangeHeaders are removed from cacheable requests before they are forwarded,
+// so that the full representation is fetched and cached. The proxy answers
+// the range itself from the complete body, or sends the request again with
+// its range if the response is not stored (see writeRetry).
+var rangeHeaders = []string{"Range", "If-Range"}
+
+// takeRangeHeaders removes the range headers from r if its response may be
+// stored, and returns their values so they can be restored with
+// restoreHeaders. Requests whose response will not be stored are
+// forwarded with their ranges intact.
+func (p *Proxy) takeRangeHeaders(r *http.Request) http.Header {
+	if r.Header.Get("Range") == "" || !p.shouldCacheRequest(r) || !p.mayStore(r) {
+		return nil
+	}
+	saved := make(http.Header)
+	for _, name := range rangeHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			saved[name] = values
+			r.Header.Del(name)
+		}
+	}
+	return saved
+}
+
+// restoreHeaders puts request headers saved by takeRangeHeaders,
+// takeValidators or takeAcceptEncoding back on r. Headers saved without values are removed.
+func restoreHeaders(r *http.Request, saved http.Header) {
+	for name, values := range saved {
+		if len(values) == 0 {
+			r.Header.Del(name)
+		} else {
+			r.Header[name] = values
+		}
+	}
+}
+
+// writeBody writes a complete response body to w, or only its headers for a
+// HEAD request. A Range request for a complete (200) response is answered
+// from body with a 206, multipart/byteranges or 416 response as appropriate,
+// honoring If-Range.
+func writeBody(w http.ResponseWriter, r *http.Request, statusCode int, body []byte) {
+	if statusCode != http.StatusOK || r.Header.Get("Range") == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		if r.Method == http.MethodHead {
+			// A HEAD response describes the body without sending it
+			if w.Header().Get("Content-Length") == "" {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			w.WriteHeader(statusCode)
+			return
+		}
+		w.WriteHeader(statusCode)
+		w.Write(body)
+		return
+	}
+	// ServeContent sets the length of the part it serves.
+	w.Header().Del("Content-Length")
+	var modtime time.Time
+	if lm := w.Header().Get("Last-Modified"); lm != "" {
+		modtime, _ = http.ParseTime(lm)
+	}
+	http.ServeContent(w, r, "", modtime, bytes.NewReader(body))
+}
@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestRangeRequests(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	const body = "0123456789abcdefghij"
+	var upstreamRanges atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" || r.Header.Get("If-Range") != "" {
+			upstreamRanges.Add(1)
+		}
+		if r.URL.Path == "/partial" {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-3/20")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(body[:4]))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte(body))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("single range on miss and hit", func(t *testing.T) {
+		for _, want := range []string{"MISS", "HIT"} {
+			w := get("/single", map[string]string{"Range": "bytes=2-5"})
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("%s: got status %d, want 206", want, w.Code)
+			}
+			if w.Body.String() != "2345" {
+				t.Errorf("%s: got body %q, want %q", want, w.Body.String(), "2345")
+			}
+			if w.Header().Get("Content-Range") != "bytes 2-5/20" {
+				t.Errorf("%s: got Content-Range %q", want, w.Header().Get("Content-Range"))
+			}
+			if w.Header().Get("X-Cache") != want {
+				t.Errorf("got X-Cache %q, want %s", w.Header().Get("X-Cache"), want)
+			}
+		}
+		if n := upstreamRanges.Load(); n != 0 {
+			t.Errorf("expected range headers not to be forwarded, got %d", n)
+		}
+
+		w := get("/single", nil)
+		if w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("expected full cached body, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		w := get("/multi", map[string]string{"Range": "bytes=0-1,10-11"})
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("got status %d, want 206", w.Code)
+		}
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("got Content-Type %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		var parts []string
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+			data, _ := io.ReadAll(part)
+			parts = append(parts, string(data))
+		}
+		if strings.Join(parts, ",") != "01,ab" {
+			t.Errorf("got parts %q, want [01 ab]", parts)
+		}
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		w := get("/unsatisfiable", map[string]string{"Range": "bytes=100-200"})
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("got status %d, want 416", w.Code)
+		}
+		if w.Header().Get("Content-Range") != "bytes */20" {
+			t.Errorf("got Content-Range %q", w.Header().Get("Content-Range"))
+		}
+	})
+
+	t.Run("if-range", func(t *testing.T) {
+		w := get("/if-range", map[string]string{"Range": "bytes=0-3", "If-Range": `"v1"`})
+		if w.Code != http.StatusPartialContent || w.Body.String() != "0123" {
+			t.Errorf("matching If-Range: got %d %q, want 206", w.Code, w.Body.String())
+		}
+		w = get("/if-range", map[string]string{"Range": "bytes=0-3", "If-Range": `"v2"`})
+		if w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("mismatched If-Range: got %d %q, want full response", w.Code, w.Body.String())
+		}
+		w = get("/if-range", map[string]string{"Range": "bytes=0-3", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"})
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("matching If-Range date: got %d, want 206", w.Code)
+		}
+	})
+
+	t.Run("partial upstream response not stored", func(t *testing.T) {
+		proxy.config.Server.DebugHeaders = true
+		defer func() { proxy.config.Server.DebugHeaders = false }()
+
+		w := get("/partial", nil)
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("got status %d, want 206", w.Code)
+		}
+		if w.Header().Get("X-Cache-Reason") != "partial" {
+			t.Errorf("got X-Cache-Reason %q, want partial", w.Header().Get("X-Cache-Reason"))
+		}
+		if w := get("/partial", nil); w.Header().Get("X-Cache") != "MISS" {
+			t.Error("expected 206 response not to be cached")
+		}
+	})
+
+	t.Run("uncacheable requests forward ranges", func(t *testing.T) {
+		before := upstreamRanges.Load()
+		w := get("/bypass", map[string]string{"Range": "bytes=0-3", BypassHeader: "1"})
+		if w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("got %d %q, want the upstream response", w.Code, w.Body.String())
+		}
+		if upstreamRanges.Load() != before+1 {
+			t.Error("expected range headers to be forwarded for a bypassed request")
+		}
+	})
+}
+
+func TestRangeRequestsHTTPS(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	var upstreamRanges atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			upstreamRanges.Add(1)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	client := newMITMClient(t, proxy)
+	for _, want := range []string{"MISS", "HIT"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/file", nil)
+		req.Header.Set("Range", "bytes=-3")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusPartialContent || string(data) != "789" {
+			t.Errorf("%s: got %d %q, want 206 \"789\"", want, resp.StatusCode, data)
+		}
+		if resp.Header.Get("Content-Range") != "bytes 7-9/10" {
+			t.Errorf("%s: got Content-Range %q", want, resp.Header.Get("Content-Range"))
+		}
+		if resp.Header.Get("X-Cache") != want {
+			t.Errorf("got X-Cache %q, want %s", resp.Header.Get("X-Cache"), want)
+		}
+	}
+	if n := upstreamRanges.Load(); n != 0 {
+		t.Errorf("expected range headers not to be forwarded, got %d", n)
+	}
+}
+
+func TestRangeRequestsNotStored(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.cache.Shutdown()
+	proxy.cache = cache.NewMemoryCache(time.Minute, 1)
+	proxy.namespaces = nil
+
+	content := bytes.Repeat([]byte("0123456789"), 3*1024*1024/10)
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/video":
+			w.Header().Set("Content-Type", "video/mp4")
+		case "/chunked":
+			w.Header().Set("Content-Type", "text/plain")
+			if r.Header.Get("Range") == "" {
+				// Stream the body without a Content-Length
+				for chunk := range slices.Chunk(content, 64*1024) {
+					w.Write(chunk)
+					w.(http.Flusher).Flush()
+				}
+				return
+			}
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// Video is not a cacheable type, and the other bodies exceed the 1 MB
+	// cache, so the ranges must reach the origin rather than turning each
+	// seek into a full download
+	for _, path := range []string{"/video", "/big", "/chunked"} {
+		t.Run(path, func(t *testing.T) {
+			mu.Lock()
+			ranges = nil
+			mu.Unlock()
+
+			req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+			req.Header.Set("Range", "bytes=0-99")
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusPartialContent || w.Body.Len() != 100 {
+				t.Errorf("got %d with %d bytes, want 206 with 100 bytes", w.Code, w.Body.Len())
+			}
+			if got := w.Header().Get("Content-Range"); got != fmt.Sprintf("bytes 0-99/%d", len(content)) {
+				t.Errorf("got Content-Range %q", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ranges) == 0 || ranges[len(ranges)-1] != "bytes=0-99" {
+				t.Errorf("got upstream ranges %q, want the client's range forwarded", ranges)
+			}
+		})
+	}
+	if n := proxy.cache.GetStats().EntryCount; n != 0 {
+		t.Errorf("got %d cache entries, want none", n)
+	}
+}
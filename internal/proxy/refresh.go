@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// GetRefreshMetrics returns the number of successful and failed refresh-ahead fetches.
+func (p *Proxy) GetRefreshMetrics() (uint64, uint64) {
+	return p.refreshes.Load(), p.refreshFailures.Load()
+}
+
+// shouldRefresh reports whether a cache hit on entry should trigger a refresh-ahead.
+// An entry qualifies once it has enough hits and has entered the last
+// threshold_percent of its TTL.
+func (p *Proxy) shouldRefresh(entry cache.CacheEntry) bool {
+	cfg := p.config.Cache.RefreshAhead
+	if !cfg.Enable || entry.Expiry.IsZero() || entry.StoredAt.IsZero() {
+		return false
+	}
+	if entry.Hits < uint64(cfg.MinHits) {
+		return false
+	}
+	ttl := entry.Expiry.Sub(entry.StoredAt)
+	remaining := time.Until(entry.Expiry)
+	return remaining <= ttl*time.Duration(cfg.ThresholdPercent)/100
+}
+
+// maybeRefresh schedules a background upstream fetch for a hot entry that is
+// close to expiry. At most one refresh per key runs at a time, and refreshes
+// are dropped rather than queued when all workers are busy.
+func (p *Proxy) maybeRefresh(namespace, key string, entry cache.CacheEntry, r *http.Request) {
+	if !p.shouldRefresh(entry) {
+		return
+	}
+
+	flightKey := namespace + " " + key
+	p.refreshMu.Lock()
+	if p.refreshInFlight[flightKey] || len(p.refreshInFlight) >= p.config.Cache.RefreshAhead.Workers {
+		p.refreshMu.Unlock()
+		return
+	}
+	p.refreshInFlight[flightKey] = true
+	p.refreshMu.Unlock()
+
+	// Carry the request's control headers, such as a TTL override, over to
+	// the refreshed entry.
+	req := r.Clone(context.WithValue(context.Background(), controlsContextKey{}, controlsFrom(r)))
+	req.Method = http.MethodGet // A HEAD hit refreshes the GET response it was served from
+	req.RequestURI = ""
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	// The refresh must fetch a complete, unconditional representation.
+	for _, h := range []string{"Proxy-Connection", "Proxy-Authorization", "If-None-Match", "If-Modified-Since", "If-Range", "Range"} {
+		req.Header.Del(h)
+	}
+	req.Header.Set("Accept-Encoding", upstreamAcceptEncoding)
+
+	p.logger.Debug("scheduling refresh-ahead", "namespace", namespace, "key", key, "hits", entry.Hits, "expiry", entry.Expiry)
+	go func() {
+		defer func() {
+			p.refreshMu.Lock()
+			delete(p.refreshInFlight, flightKey)
+			p.refreshMu.Unlock()
+		}()
+
+		if err := p.refresh(namespace, key, req, entry.Hits); err != nil {
+			p.refreshFailures.Add(1)
+			p.logger.Warn("refresh-ahead failed", "key", key, "error", err)
+			return
+		}
+		p.refreshes.Add(1)
+		p.logger.Info("entry refreshed ahead of expiry", "key", key)
+	}()
+}
+
+// refresh fetches req upstream and replaces the cached entry for key in namespace.
+// The previous entry is kept if the new response is an error, not cacheable,
+// or larger than the cache will store.
+func (p *Proxy) refresh(namespace, key string, req *http.Request, hits uint64) error {
+	c, ok := p.namespaceCache(namespace)
+	if !ok {
+		return fmt.Errorf("unknown cache namespace %q", namespace)
+	}
+
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	limit := entryLimit(c)
+	body, complete, err := readAhead(resp.Body, limit)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		return fmt.Errorf("upstream response exceeds the %d byte size limit", limit)
+	}
+	if isErrorStatusCode(resp.StatusCode) {
+		return fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	if !p.shouldCacheResponse(req, resp, decodedPrefix(contentCoding(resp.Header), body)) {
+		return fmt.Errorf("upstream response is no longer cacheable")
+	}
+
+	entry, err := canonicalEntry(cache.CacheEntry{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header,
+		Body:       body,
+		Hits:       hits, // Keep the entry hot so it continues to be refreshed
+	})
+	if err != nil {
+		return err
+	}
+	p.storeEntry(c, req, key, entry)
+	return nil
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestShouldRefresh(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	proxy.config.Cache.RefreshAhead.Enable = true
+	proxy.config.Cache.RefreshAhead.MinHits = 5
+	proxy.config.Cache.RefreshAhead.ThresholdPercent = 20
+
+	now := time.Now()
+	tests := []struct {
+		name  string
+		entry cache.CacheEntry
+		want  bool
+	}{
+		{"hot and near expiry", cache.CacheEntry{Hits: 5, StoredAt: now.Add(-90 * time.Second), Expiry: now.Add(10 * time.Second)}, true},
+		{"not enough hits", cache.CacheEntry{Hits: 4, StoredAt: now.Add(-90 * time.Second), Expiry: now.Add(10 * time.Second)}, false},
+		{"early in TTL", cache.CacheEntry{Hits: 50, StoredAt: now.Add(-10 * time.Second), Expiry: now.Add(90 * time.Second)}, false},
+		{"never expires", cache.CacheEntry{Hits: 50, StoredAt: now}, false},
+		{"unknown store time", cache.CacheEntry{Hits: 50, Expiry: now.Add(time.Second)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxy.shouldRefresh(tt.entry); got != tt.want {
+				t.Errorf("shouldRefresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	proxy.config.Cache.RefreshAhead.Enable = false
+	if proxy.shouldRefresh(tests[0].entry) {
+		t.Error("expected no refresh when disabled")
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	var version atomic.Int32
+	var failNext atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failNext.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "version %d", version.Add(1))
+	}))
+	defer server.Close()
+
+	// Every hit on an entry is close enough to expiry to trigger a refresh.
+	proxy.config.Cache.RefreshAhead.Enable = true
+	proxy.config.Cache.RefreshAhead.MinHits = 1
+	proxy.config.Cache.RefreshAhead.ThresholdPercent = 100
+
+	get := func() string {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/hot", nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if body := get(); body != "version 1" {
+		t.Fatalf("got %q on miss, want version 1", body)
+	}
+	// The hit is served from cache and schedules a refresh in the background.
+	if body := get(); body != "version 1" {
+		t.Fatalf("got %q on hit, want version 1", body)
+	}
+
+	waitFor(t, func() bool {
+		refreshed, _ := proxy.GetRefreshMetrics()
+		return refreshed == 1
+	})
+	entry, ok := proxy.cache.Get(server.URL + "/hot")
+	if !ok || string(entry.Body) != "version 2" {
+		t.Fatalf("expected refreshed entry with version 2, got %q (found=%v)", entry.Body, ok)
+	}
+	if entry.Hits < 2 {
+		t.Errorf("expected hit count to carry over, got %d", entry.Hits)
+	}
+
+	// A failed refresh keeps the existing entry.
+	failNext.Store(true)
+	get()
+	waitFor(t, func() bool {
+		_, failed := proxy.GetRefreshMetrics()
+		return failed == 1
+	})
+	entry, ok = proxy.cache.Get(server.URL + "/hot")
+	if !ok || string(entry.Body) != "version 2" {
+		t.Errorf("expected previous entry to survive failed refresh, got %q (found=%v)", entry.Body, ok)
+	}
+}
+
+func TestRefreshAheadKeepsControlsAndLimit(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.cache.Shutdown()
+	proxy.cache = cache.NewMemoryCache(time.Minute, 1)
+	proxy.namespaces = nil
+
+	var grow atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if grow.Load() {
+			w.Write(bytes.Repeat([]byte("x"), 2*1024*1024))
+			return
+		}
+		w.Write([]byte("small"))
+	}))
+	defer server.Close()
+
+	proxy.config.Cache.RefreshAhead.Enable = true
+	proxy.config.Cache.RefreshAhead.MinHits = 1
+	proxy.config.Cache.RefreshAhead.ThresholdPercent = 100
+
+	get := func() {
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/hot", nil)
+		req.Header.Set(TTLHeader, "24h")
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The refreshed entry keeps the TTL override of the request that hit it
+	get()
+	get()
+	waitFor(t, func() bool {
+		refreshed, _ := proxy.GetRefreshMetrics()
+		return refreshed == 1
+	})
+	entry, ok := proxy.cache.Peek(server.URL + "/hot")
+	if !ok || entry.Expiry.Sub(entry.StoredAt) != 24*time.Hour {
+		t.Errorf("expected the refreshed entry to keep the 24h TTL, got %v", entry.Expiry.Sub(entry.StoredAt))
+	}
+
+	// A body beyond the 1 MB cache is dropped and the entry kept
+	grow.Store(true)
+	get()
+	waitFor(t, func() bool {
+		_, failed := proxy.GetRefreshMetrics()
+		return failed == 1
+	})
+	entry, ok = proxy.cache.Peek(server.URL + "/hot")
+	if !ok || string(entry.Body) != "small" {
+		t.Errorf("expected the entry to survive an oversized refresh, got %d bytes (found=%v)", len(entry.Body), ok)
+	}
+}
+
+// waitFor polls cond until it is true or a timeout expires.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+// ruleFor returns the first [[cache.rules]] entry matching r, or nil. HEAD
+// requests match the rules for GET, whose cached responses answer them.
+func (p *Proxy) ruleFor(r *http.Request) *config.CacheRule {
+	if r == nil || r.URL == nil {
+		return nil
+	}
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	return p.config.Cache.MatchRule(method, r.URL.Hostname(), r.URL.Path)
+}
+
+// postCacheEnabled reports whether POST caching applies to r, taking a
+// matching rule's post_cache and never_cache settings into account. POST
+// caching is enabled by post_cache.enable or by listing POST in
+// cacheable_methods.
+func (p *Proxy) postCacheEnabled(r *http.Request) bool {
+	rule := p.ruleFor(r)
+	if rule != nil && rule.NeverCache {
+		return false
+	}
+	if rule != nil && rule.PostCache != nil {
+		return *rule.PostCache
+	}
+	return p.config.Cache.PostCache.Enable || p.config.Cache.MethodCacheable(http.MethodPost)
+}
+
+// bodyCacheable reports whether r has a cacheable method other than GET or
+// HEAD, such as POST or QUERY. Responses to these requests are keyed by a
+// hash of the request body, see bodyCacheKey.
+func (p *Proxy) bodyCacheable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return false
+	case http.MethodPost:
+		return p.postCacheEnabled(r)
+	}
+	if rule := p.ruleFor(r); rule != nil && rule.NeverCache {
+		return false
+	}
+	return p.config.Cache.MethodCacheable(r.Method)
+}
+
+// cacheableTypes returns the content types that may be cached for r.
+func (p *Proxy) cacheableTypes(rule *config.CacheRule) []string {
+	if rule != nil && len(rule.CacheableTypes) > 0 {
+		return rule.CacheableTypes
+	}
+	return p.config.Cache.CacheableTypes
+}
+
+// ignoreNoCache reports whether no-cache directives are ignored for a rule.
+func (p *Proxy) ignoreNoCache(rule *config.CacheRule) bool {
+	if rule != nil && rule.IgnoreNoCache != nil {
+		return *rule.IgnoreNoCache
+	}
+	return p.config.Cache.IgnoreNoCache
+}
+
+// storeEntry caches a successful response. The TTL comes from the
+// X-GoCache-TTL header, then the rule matching r, then the status_ttl entry
+// for the response status, and is the cache's default TTL otherwise.
+func (p *Proxy) storeEntry(c *cache.MemoryCache, r *http.Request, key string, entry cache.CacheEntry) {
+	entry = p.withETag(entry)
+	if ttl := controlsFrom(r).ttl; ttl > 0 {
+		c.SetWithTTL(key, entry, ttl)
+		return
+	}
+	if ttl, ok := p.ruleTTL(r); ok {
+		c.SetWithTTL(key, entry, ttl)
+		return
+	}
+	if ttl, ok := p.config.Cache.GetStatusTTL(entry.StatusCode); ok {
+		c.SetWithTTL(key, entry, ttl)
+		return
+	}
+	c.Set(key, entry)
+}
+
+// ruleTTL returns the TTL set by the rule matching r, if any.
+func (p *Proxy) ruleTTL(r *http.Request) (time.Duration, bool) {
+	if rule := p.ruleFor(r); rule != nil {
+		return rule.GetTTL()
+	}
+	return 0, false
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/config"
+	"github.com/gbmerrall/gocache/internal/logging"
+)
+
+func TestCacheRules(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/img/"):
+			w.Header().Set("Content-Type", "image/png")
+		case strings.HasPrefix(r.URL.Path, "/nocache/"):
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Cache-Control", "no-cache")
+		case strings.HasPrefix(r.URL.Path, "/missing/"):
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	yes := true
+	proxy.config.Cache.Rules = []config.CacheRule{
+		{Name: "private", PathPrefix: "/account/", NeverCache: true},
+		{Name: "short", PathPrefix: "/short/", TTL: "5s"},
+		{Name: "images", PathPrefix: "/img/", CacheableTypes: []string{"image/"}},
+		{Name: "nocache", PathPrefix: "/nocache/", IgnoreNoCache: &yes},
+		{Name: "missing", PathPrefix: "/missing/", NegativeTTL: "7s"},
+		{Name: "search", PathPrefix: "/search", Key: config.CacheKeyRules{IgnoreParams: []string{"session"}}},
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.URL+path, nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	ttlOf := func(path string) time.Duration {
+		key := proxy.cacheKey(httptest.NewRequest(http.MethodGet, server.URL+path, nil))
+		entry, ok := proxy.cache.Peek(key)
+		if !ok {
+			return 0
+		}
+		return time.Until(entry.Expiry)
+	}
+
+	t.Run("never cache", func(t *testing.T) {
+		get("/account/me")
+		if w := get("/account/me"); w.Header().Get("X-Cache") == "HIT" {
+			t.Error("expected never_cache rule to bypass the cache")
+		}
+	})
+
+	t.Run("ttl override", func(t *testing.T) {
+		get("/short/a")
+		if ttl := ttlOf("/short/a"); ttl <= 0 || ttl > 5*time.Second {
+			t.Errorf("got ttl %v, want at most 5s", ttl)
+		}
+		get("/long/a")
+		if ttl := ttlOf("/long/a"); ttl <= 5*time.Second {
+			t.Errorf("got ttl %v, want the default TTL", ttl)
+		}
+	})
+
+	t.Run("cacheable types", func(t *testing.T) {
+		get("/img/logo.png")
+		if w := get("/img/logo.png"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected image to be cached under the images rule")
+		}
+	})
+
+	t.Run("ignore no-cache", func(t *testing.T) {
+		get("/nocache/a")
+		if w := get("/nocache/a"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected no-cache to be ignored under the nocache rule")
+		}
+	})
+
+	t.Run("negative ttl", func(t *testing.T) {
+		get("/missing/a")
+		if ttl := ttlOf("/missing/a"); ttl <= 0 || ttl > 7*time.Second {
+			t.Errorf("got ttl %v, want at most 7s", ttl)
+		}
+	})
+
+	t.Run("key override", func(t *testing.T) {
+		get("/search?q=go&session=1")
+		if w := get("/search?q=go&session=2"); w.Header().Get("X-Cache") != "HIT" {
+			t.Error("expected session param to be ignored under the search rule")
+		}
+		get("/other?q=go&session=1")
+		if w := get("/other?q=go&session=2"); w.Header().Get("X-Cache") == "HIT" {
+			t.Error("expected session param to be part of the key outside the search rule")
+		}
+	})
+}
+
+func TestCacheRulePostCache(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	yes := true
+	proxy.config.Cache.PostCache.Enable = false
+	proxy.config.Cache.Rules = []config.CacheRule{
+		{Name: "graphql", PathPrefix: "/graphql", Methods: []string{"POST"}, PostCache: &yes},
+	}
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(`{"query":"{a}"}`))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	post("/graphql")
+	if w := post("/graphql"); w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected POST caching to be enabled by the graphql rule")
+	}
+	post("/rest")
+	if w := post("/rest"); w.Header().Get("X-Cache") == "HIT" {
+		t.Error("expected POST caching to stay disabled outside the rule")
+	}
+}
+
+func TestCacheRuleAccessLog(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := logging.NewAccessLogger(logging.AccessLoggerConfig{
+		Format:     logging.FormatJSON,
+		LogFile:    logFile,
+		BufferSize: 10,
+	})
+	if err != nil {
+		t.Fatalf("failed to create access logger: %v", err)
+	}
+	proxy.accessLog = accessLog
+	proxy.config.Cache.Rules = []config.CacheRule{{Name: "docs", PathPrefix: "/docs/"}}
+
+	for _, path := range []string{"/docs/a", "/other"} {
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, server.URL+path, nil))
+	}
+	accessLog.Close()
+
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("failed to read access log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 access log entries, got %d", len(lines))
+	}
+	for i, want := range []string{"docs", ""} {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("failed to parse access log entry: %v", err)
+		}
+		got, _ := entry["rule"].(string)
+		if got != want {
+			t.Errorf("entry %d: got rule %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestStatusTTL(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var code int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/"), "%d", &code)
+		if code == http.StatusMovedPermanently {
+			w.Header().Set("Location", "/elsewhere")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(code)
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	proxy.config.Cache.StatusTTL = map[string]string{
+		"404": "1h",
+		"429": "0s",
+		"5xx": "10s",
+		"503": "5s",
+		"301": "24h",
+	}
+
+	tests := []struct {
+		path   string
+		want   time.Duration
+		cached bool
+	}{
+		{"/404", time.Hour, true},
+		{"/429", 0, false},
+		{"/502", 10 * time.Second, true},
+		{"/503", 5 * time.Second, true},
+		{"/301", 24 * time.Hour, true},
+		{"/200", time.Minute, true}, // cache default TTL
+		{"/400", proxy.config.Cache.GetNegativeTTL(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+			entry, ok := proxy.cache.Peek(proxy.cacheKey(req))
+			if ok != tt.cached {
+				t.Fatalf("cached = %v, want %v", ok, tt.cached)
+			}
+			if !ok {
+				return
+			}
+			if ttl := entry.Expiry.Sub(entry.StoredAt); ttl != tt.want {
+				t.Errorf("got ttl %v, want %v", ttl, tt.want)
+			}
+		})
+	}
+
+	t.Run("rule negative ttl wins", func(t *testing.T) {
+		proxy.config.Cache.Rules = []config.CacheRule{{Name: "r", PathPrefix: "/404", NegativeTTL: "3s"}}
+		defer func() { proxy.config.Cache.Rules = nil }()
+
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/404?rule", nil)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+		entry, ok := proxy.cache.Peek(proxy.cacheKey(req))
+		if !ok || entry.Expiry.Sub(entry.StoredAt) != 3*time.Second {
+			t.Errorf("expected rule negative_ttl of 3s, got %+v", entry)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+// maxDebugReadAhead bounds how much of a body is read ahead so the debug
+// headers can report whether it was stored. Longer bodies are streamed and
+// those headers are left out.
+const maxDebugReadAhead = 1 << 20
+
+// errResponseTruncated is returned by writeUpstream when a response was cut
+// short after its headers had been sent to the client.
+var errResponseTruncated = errors.New("response truncated")
+
+// upstreamResponse is a response fetched from upstream for a request that was
+// not served from the cache.
+type upstreamResponse struct {
+	*http.Response
+	cache       *cache.MemoryCache
+	namespace   string
+	key         string
+	reason      string    // Why the request may not be cached, or ""
+	cacheStatus string    // X-Cache value; empty for requests that are never cached
+	limit       int64     // Largest body that may be cached; 0 means no limit
+	start       time.Time // When the upstream request was sent
+	fullBody    bool      // Range or validator headers were held back to fetch the complete body
+}
+
+// mayStore reports whether the client of r allows its response to be stored.
+func (p *Proxy) mayStore(r *http.Request) bool {
+	ctl := controlsFrom(r)
+	return !ctl.bypass && !ctl.noStore && !p.clientNoStore(r)
+}
+
+// entryLimit returns the largest body c will store, or 0 for no limit.
+func entryLimit(c *cache.MemoryCache) int64 {
+	return c.GetStats().MaxSize
+}
+
+// writeUpstream sends u to w and stores it in the cache if it may be cached.
+// The body is streamed to the client while a copy is kept for the cache. The
+// copy is abandoned if it grows beyond u.limit or the upstream fails mid-body,
+// so truncated bodies are never cached. Range and conditional requests are
+// answered from the complete body only if the response is stored: it is read
+// ahead as long as it fits within u.limit. Otherwise the client's own request
+// is sent again and its response relayed, see writeRetry. With debug headers
+// on, at most maxDebugReadAhead bytes are read ahead.
+//
+// An encoded body is passed through to clients that accept its coding and
+// decoded on the fly for those that do not. It is stored decoded, see
+// canonicalEntry.
+//
+// A successful response to an unsafe request invalidates the cached responses
+// for its URL before it is sent, see invalidate.
+//
+// Errors before anything was written leave w untouched; errors after the
+// headers were sent wrap errResponseTruncated.
+func (p *Proxy) writeUpstream(w http.ResponseWriter, r *http.Request, u upstreamResponse) error {
+	p.invalidate(u.cache, r, u.Response)
+
+	upstream := bufio.NewReader(u.Body)
+	reason := u.reason
+	if reason == "" {
+		reason = p.responseNotCacheable(r, u.Response, p.sniffPrefix(u.Response, upstream))
+	}
+	coding := contentCoding(u.Header)
+	if reason == "" && !canDecode(coding) {
+		reason = "content-encoding"
+	}
+
+	if u.fullBody && reason == "" && u.limit > 0 && u.ContentLength > u.limit {
+		reason = "too-large"
+	}
+	if u.fullBody && reason != "" {
+		return p.writeRetry(w, r, u, reason)
+	}
+
+	var body io.Reader = upstream
+	if u.fullBody || p.debugEnabled(r) {
+		limit := u.limit
+		if !u.fullBody && (limit <= 0 || limit > maxDebugReadAhead) {
+			limit = maxDebugReadAhead
+		}
+		data, complete, err := readAhead(upstream, limit)
+		if err != nil {
+			return err
+		}
+		if complete {
+			return p.writeComplete(w, r, u, reason, data)
+		}
+		if u.fullBody {
+			return p.writeRetry(w, r, u, "too-large")
+		}
+		// A body cut short by the debug bound may still fit in the cache
+		if reason == "" && u.limit > 0 && int64(len(data)) > u.limit {
+			reason = "too-large"
+		}
+		body = io.MultiReader(bytes.NewReader(data), upstream)
+	}
+
+	h := w.Header()
+	copyHeaders(h, u.Header)
+	if u.cacheStatus != "" {
+		h.Set("X-Cache", u.cacheStatus)
+	}
+	bodyCoding := coding
+	if canDecode(coding) {
+		addVary(h, "Accept-Encoding")
+		if coding != "" && !acceptsCoding(r, coding) {
+			decoded, err := newDecoder(coding, body)
+			if err != nil {
+				return err
+			}
+			body, bodyCoding = decoded, ""
+			h.Del("Content-Encoding")
+			h.Del("Content-Length")
+		}
+	}
+	p.setDebugHeaders(h, r, debugInfo{key: u.key, reason: reason, upstream: time.Since(u.start)})
+
+	tee := newCacheTee(body, u.limit)
+	if reason != "" {
+		tee.abandon()
+	}
+	w.WriteHeader(u.StatusCode)
+	if _, err := io.Copy(flushWriter{w}, tee); err != nil {
+		return fmt.Errorf("%w: %v", errResponseTruncated, err)
+	}
+
+	data, ok := tee.body()
+	switch {
+	case ok:
+		if entry, err := upstreamEntry(u, bodyCoding, data); err != nil {
+			p.logger.Debug("response not cached: cannot decode body", "key", u.key, "error", err)
+		} else {
+			p.cacheResponse(r, u, entry)
+		}
+	case reason == "":
+		p.logger.Debug("response not cached: body exceeds size limit", "key", u.key, "limit_bytes", u.limit)
+	default:
+		p.logger.Debug("response not cached", "key", u.key, "statusCode", u.StatusCode, "reason", reason)
+	}
+	return nil
+}
+
+// writeRetry relays the response to the client's own request r, with the
+// Range and validator headers that were held back from the request for u, when
+// u turns out not to be stored. The client then gets the 206 or 304 the
+// origin sends rather than the whole body.
+func (p *Proxy) writeRetry(w http.ResponseWriter, r *http.Request, u upstreamResponse, reason string) error {
+	p.logger.Debug("response not stored, resending request with its range and validators", "key", u.key, "reason", reason)
+	u.Body.Close()
+	resp, err := p.transport.RoundTrip(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	u.Response = resp
+	u.reason = reason
+	u.fullBody = false
+	return p.writeUpstream(w, r, u)
+}
+
+// writeComplete stores and sends an upstream response whose whole body has
+// been read, in the content coding the client prefers. Range and validator
+// headers held back from upstream are only answered here if the entry was
+// stored; otherwise the request is resent with them, see writeRetry.
+func (p *Proxy) writeComplete(w http.ResponseWriter, r *http.Request, u upstreamResponse, reason string, data []byte) error {
+	info := debugInfo{key: u.key, upstream: time.Since(u.start)}
+	entry, err := upstreamEntry(u, contentCoding(u.Header), data)
+	if err != nil {
+		// Pass a body that cannot be decoded through as it is
+		entry = cache.CacheEntry{StatusCode: u.StatusCode, Headers: u.Header, Body: data}
+		if reason == "" {
+			reason = "content-encoding"
+		}
+	}
+	if u.fullBody && reason != "" {
+		return p.writeRetry(w, r, u, reason)
+	}
+	if reason == "" {
+		p.cacheResponse(r, u, entry)
+	} else {
+		p.logger.Debug("response not cached", "key", u.key, "statusCode", u.StatusCode, "reason", reason)
+	}
+	info.reason = reason
+	p.writeEntry(w, r, nil, u.key, entry, u.cacheStatus, storedDebugInfo(u.cache, u.key, u.start, info))
+	return nil
+}
+
+// upstreamEntry returns the cache entry for a body of u in the given content
+// coding, converted to its canonical form.
+func upstreamEntry(u upstreamResponse, coding string, body []byte) (cache.CacheEntry, error) {
+	header := u.Header
+	if coding == "" && contentCoding(header) != "" {
+		// The body was decoded on its way to the client
+		header = header.Clone()
+		header.Del("Content-Encoding")
+	}
+	return canonicalEntry(cache.CacheEntry{StatusCode: u.StatusCode, Headers: header, Body: body})
+}
+
+// copyHeaders adds the headers in src to dst.
+func copyHeaders(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+// cacheResponse stores an entry for u in the cache, using the negative TTL
+// for error responses.
+func (p *Proxy) cacheResponse(r *http.Request, u upstreamResponse, entry cache.CacheEntry) {
+	// Use negative TTL for error status codes (4xx, 5xx)
+	if isErrorStatusCode(u.StatusCode) {
+		negativeTTL := p.negativeTTL(u.namespace, r, u.StatusCode)
+		u.cache.SetWithTTL(u.key, entry, negativeTTL)
+		p.logger.Info("response cached with negative TTL", "key", u.key, "ttl", negativeTTL)
+		p.logger.Debug("cached error response details", "statusCode", u.StatusCode, "contentType", u.Header.Get("Content-Type"), "bodySize", len(entry.Body), "negativeTTL", negativeTTL)
+		return
+	}
+	p.storeEntry(u.cache, r, u.key, entry)
+	p.logger.Info("response cached", "key", u.key)
+	p.logger.Debug("cached response details", "statusCode", u.StatusCode, "contentType", u.Header.Get("Content-Type"), "bodySize", len(entry.Body))
+}
+
+// readAhead reads r to the end, or until it has read more than limit bytes
+// (0 means no limit). complete reports whether data is the whole body; if it
+// is not, the rest of the body remains in r.
+func readAhead(r io.Reader, limit int64) (data []byte, complete bool, err error) {
+	if limit <= 0 {
+		data, err = io.ReadAll(r)
+		return data, err == nil, err
+	}
+	data, err = io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	return data, int64(len(data)) <= limit, nil
+}
+
+// cacheTee passes a response body through while keeping a copy of it for the
+// cache. The copy is dropped, without affecting the reader, once it grows
+// beyond limit bytes (0 means no limit).
+type cacheTee struct {
+	r         io.Reader
+	limit     int64
+	buf       bytes.Buffer
+	abandoned bool
+	eof       bool
+}
+
+func newCacheTee(r io.Reader, limit int64) *cacheTee {
+	return &cacheTee{r: r, limit: limit}
+}
+
+func (t *cacheTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && !t.abandoned {
+		if t.limit > 0 && int64(t.buf.Len()+n) > t.limit {
+			t.abandon()
+		} else {
+			t.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		t.eof = true
+	}
+	return n, err
+}
+
+// abandon drops the copy of the body.
+func (t *cacheTee) abandon() {
+	t.abandoned = true
+	t.buf = bytes.Buffer{}
+}
+
+// body returns the copy of the body if it was read to the end and kept.
+func (t *cacheTee) body() ([]byte, bool) {
+	if !t.eof || t.abandoned {
+		return nil, false
+	}
+	return t.buf.Bytes(), true
+}
+
+// flushWriter flushes w after every write, so a streamed body reaches the
+// client as it arrives from upstream.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		// Writers that cannot flush are written through as is.
+		_ = http.NewResponseController(f.w).Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/cache"
+)
+
+func TestCacheTee(t *testing.T) {
+	t.Run("complete body is kept", func(t *testing.T) {
+		tee := newCacheTee(strings.NewReader("hello"), 10)
+		data, _ := io.ReadAll(tee)
+		body, ok := tee.body()
+		if !ok || string(body) != "hello" || string(data) != "hello" {
+			t.Errorf("got %q %v, want hello", body, ok)
+		}
+	})
+
+	t.Run("copy dropped past limit", func(t *testing.T) {
+		tee := newCacheTee(strings.NewReader("hello world"), 5)
+		data, _ := io.ReadAll(tee)
+		if string(data) != "hello world" {
+			t.Errorf("reader returned %q, want the whole body", data)
+		}
+		if _, ok := tee.body(); ok {
+			t.Error("expected copy to be dropped")
+		}
+	})
+
+	t.Run("incomplete body is not kept", func(t *testing.T) {
+		tee := newCacheTee(io.MultiReader(strings.NewReader("hel"), iotestErrReader{}), 0)
+		if _, err := io.ReadAll(tee); err == nil {
+			t.Fatal("expected read error")
+		}
+		if _, ok := tee.body(); ok {
+			t.Error("expected truncated body not to be kept")
+		}
+	})
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func TestReadAhead(t *testing.T) {
+	data, complete, err := readAhead(strings.NewReader("12345"), 5)
+	if err != nil || !complete || string(data) != "12345" {
+		t.Errorf("got %q %v %v, want complete body", data, complete, err)
+	}
+
+	r := strings.NewReader("123456")
+	data, complete, err = readAhead(r, 5)
+	if err != nil || complete {
+		t.Fatalf("got complete=%v err=%v, want incomplete", complete, err)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(data)+string(rest) != "123456" {
+		t.Errorf("got %q + %q, want the whole body", data, rest)
+	}
+}
+
+func TestConnResponseWriter(t *testing.T) {
+	read := func(t *testing.T, out *bytes.Buffer, method string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.ReadResponse(bufio.NewReader(out), &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		return resp, string(body)
+	}
+
+	t.Run("chunked without content length", func(t *testing.T) {
+		var out bytes.Buffer
+		cw := newConnResponseWriter(&out, httptest.NewRequest(http.MethodGet, "/", nil))
+		cw.Header().Set("X-Test", "1")
+		cw.Write([]byte("hello "))
+		cw.Write([]byte("world"))
+		if err := cw.finish(); err != nil {
+			t.Fatal(err)
+		}
+		resp, body := read(t, &out, http.MethodGet)
+		if body != "hello world" || resp.Header.Get("X-Test") != "1" {
+			t.Errorf("got %q, headers %v", body, resp.Header)
+		}
+		if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("expected chunked response, got %v", resp.TransferEncoding)
+		}
+		if cw.size != 11 {
+			t.Errorf("got size %d, want 11", cw.size)
+		}
+	})
+
+	t.Run("content length", func(t *testing.T) {
+		var out bytes.Buffer
+		cw := newConnResponseWriter(&out, httptest.NewRequest(http.MethodGet, "/", nil))
+		cw.Header().Set("Content-Length", "5")
+		cw.WriteHeader(http.StatusCreated)
+		cw.Write([]byte("hello"))
+		cw.finish()
+		resp, body := read(t, &out, http.MethodGet)
+		if resp.StatusCode != http.StatusCreated || resp.ContentLength != 5 || body != "hello" {
+			t.Errorf("got %d %d %q", resp.StatusCode, resp.ContentLength, body)
+		}
+	})
+
+	t.Run("head has no body", func(t *testing.T) {
+		var out bytes.Buffer
+		cw := newConnResponseWriter(&out, httptest.NewRequest(http.MethodHead, "/", nil))
+		cw.Header().Set("Content-Length", "5")
+		cw.Write([]byte("hello"))
+		cw.finish()
+		if strings.Contains(out.String(), "hello") {
+			t.Errorf("expected no body for HEAD, got %q", out.String())
+		}
+	})
+}
+
+// streamingUpstream returns a server whose /stream response sends its first
+// chunk and then waits for release before finishing. /truncated declares a
+// longer body than it sends.
+func streamingUpstream(t *testing.T, tls bool, release chan struct{}) *httptest.Server {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/stream":
+			w.Write([]byte("first,"))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("second"))
+		case "/truncated":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case "/big":
+			w.Write(bytes.Repeat([]byte("x"), 2*1024*1024))
+		}
+	})
+	if tls {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+func TestStreamingResponses(t *testing.T) {
+	for _, scheme := range []string{"http", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			proxy, cleanup := setupTestProxy(t)
+			defer cleanup()
+			proxy.cache.Shutdown()
+			proxy.cache = cache.NewMemoryCache(time.Minute, 1)
+			proxy.namespaces = nil
+
+			release := make(chan struct{})
+			upstream := streamingUpstream(t, scheme == "https", release)
+			defer upstream.Close()
+
+			var client *http.Client
+			if scheme == "https" {
+				client = newMITMClient(t, proxy)
+			} else {
+				proxyServer := httptest.NewServer(proxy)
+				defer proxyServer.Close()
+				proxyURL, _ := url.Parse(proxyServer.URL)
+				client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			}
+
+			get := func(path string) (*http.Response, error) {
+				return client.Get(upstream.URL + path)
+			}
+
+			t.Run("first bytes before upstream finishes", func(t *testing.T) {
+				type result struct {
+					resp *http.Response
+					err  error
+				}
+				first := make([]byte, len("first,"))
+				done := make(chan result, 1)
+				go func() {
+					resp, err := get("/stream")
+					if err == nil {
+						_, err = io.ReadFull(resp.Body, first)
+					}
+					done <- result{resp, err}
+				}()
+
+				var res result
+				select {
+				case res = <-done:
+				case <-time.After(5 * time.Second):
+					close(release)
+					t.Fatal("first chunk not received before upstream finished")
+				}
+				close(release)
+				if res.err != nil {
+					t.Fatalf("failed to read first chunk: %v", res.err)
+				}
+				resp := res.resp
+				defer resp.Body.Close()
+				rest, _ := io.ReadAll(resp.Body)
+				if string(first)+string(rest) != "first,second" {
+					t.Errorf("got %q, want first,second", string(first)+string(rest))
+				}
+
+				resp, err := get("/stream")
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.Header.Get("X-Cache") != "HIT" {
+					t.Errorf("expected streamed response to be cached, got X-Cache %q", resp.Header.Get("X-Cache"))
+				}
+			})
+
+			t.Run("body larger than cache is streamed but not cached", func(t *testing.T) {
+				for i := 0; i < 2; i++ {
+					resp, err := get("/big")
+					if err != nil {
+						t.Fatalf("request failed: %v", err)
+					}
+					n, _ := io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if n != 2*1024*1024 {
+						t.Errorf("got %d bytes, want the whole body", n)
+					}
+					if resp.Header.Get("X-Cache") != "MISS" {
+						t.Errorf("request %d: got X-Cache %q, want MISS", i, resp.Header.Get("X-Cache"))
+					}
+				}
+			})
+
+			t.Run("truncated upstream body is not cached", func(t *testing.T) {
+				for i := 0; i < 2; i++ {
+					resp, err := get("/truncated")
+					if err != nil {
+						t.Fatalf("request failed: %v", err)
+					}
+					_, err = io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err == nil {
+						t.Error("expected the client to see the truncated body")
+					}
+					if resp.Header.Get("X-Cache") != "MISS" {
+						t.Errorf("request %d: got X-Cache %q, want MISS", i, resp.Header.Get("X-Cache"))
+					}
+				}
+				if _, ok := proxy.cache.Peek(proxy.cacheKey(httptest.NewRequest(http.MethodGet, upstream.URL+"/truncated", nil))); ok {
+					t.Error("expected truncated body not to be cached")
+				}
+			})
+		})
+	}
+}
+
+func TestDebugHeadersBoundReadAhead(t *testing.T) {
+	proxy, cleanup := setupTestProxy(t)
+	defer cleanup()
+	proxy.cache.Shutdown()
+	proxy.cache = cache.NewMemoryCache(time.Minute, 0)
+	proxy.namespaces = nil
+	proxy.config.Server.DebugHeaders = true
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(bytes.Repeat([]byte("x"), 2*maxDebugReadAhead))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("end"))
+	}))
+	defer upstream.Close()
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// Without a size limit, the body must not be held back until upstream
+	// finishes just to compute the debug headers
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.Get(upstream.URL + "/large")
+		done <- result{resp, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("response headers not received before upstream finished")
+	}
+	close(release)
+	if res.err != nil {
+		t.Fatalf("request failed: %v", res.err)
+	}
+	n, _ := io.Copy(io.Discard, res.resp.Body)
+	res.resp.Body.Close()
+	if n != 2*maxDebugReadAhead+3 {
+		t.Errorf("got %d bytes, want the whole body", n)
+	}
+	if res.resp.Header.Get("X-Cache-Key") == "" || res.resp.Header.Get("X-Cache-Reason") != "" {
+		t.Errorf("got debug headers %v, want the key and no reason", res.resp.Header)
+	}
+
+	resp, err := client.Get(upstream.URL + "/large")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("got X-Cache %q, want the streamed body cached", resp.Header.Get("X-Cache"))
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/logging"
+)
+
+// tunnelDialTimeout bounds how long a tunnel waits for the origin to accept
+// its connection.
+const tunnelDialTimeout = 30 * time.Second
+
+// GetTunnelCount returns the number of CONNECT requests tunneled to the
+// origin without interception.
+func (p *Proxy) GetTunnelCount() uint64 {
+	return p.tunnels.Load()
+}
+
+// shouldIntercept reports whether the CONNECT request r is intercepted, as
+// opposed to tunneled, according to the [mitm] host list and the hosts whose
+// clients rejected the proxy's certificate.
+func (p *Proxy) shouldIntercept(r *http.Request) bool {
+	host := connectHostname(r)
+	if _, rejected := p.rejectedHosts.Load(host); rejected {
+		return false
+	}
+	return p.config.MITM.Intercepts(host)
+}
+
+// connectHostname returns the lowercased host name of a CONNECT target.
+func connectHostname(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return strings.ToLower(host)
+}
+
+// rememberRejection records that the client of the CONNECT request r failed
+// the TLS handshake with the proxy's certificate, so later connections to the
+// host are tunneled when fallback_on_reject is set. Clients that pin
+// certificates abort the handshake with an alert.
+func (p *Proxy) rememberRejection(r *http.Request, err error) {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		return
+	}
+	host := connectHostname(r)
+	if !p.config.MITM.FallbackOnReject {
+		p.logger.Warn("client rejected the proxy certificate", "host", host, "error", err)
+	} else if _, loaded := p.rejectedHosts.LoadOrStore(host, struct{}{}); !loaded {
+		p.logger.Warn("client rejected the proxy certificate, tunneling host until the config is reloaded", "host", host, "error", err)
+	}
+	p.certRejections.Add(1)
+}
+
+// GetCertRejectionCount returns the number of TLS handshakes that clients
+// aborted because they rejected the proxy's certificate.
+func (p *Proxy) GetCertRejectionCount() uint64 {
+	return p.certRejections.Load()
+}
+
+// GetRejectedHosts returns the sorted hosts that are tunneled because their
+// clients rejected the proxy's certificate.
+func (p *Proxy) GetRejectedHosts() []string {
+	hosts := []string{}
+	p.rejectedHosts.Range(func(host, _ any) bool {
+		hosts = append(hosts, host.(string))
+		return true
+	})
+	sort.Strings(hosts)
+	return hosts
+}
+
+// handleTunnel relays the CONNECT request r to its origin without
+// intercepting it, copying bytes in both directions until both sides are
+// done.
+func (p *Proxy) handleTunnel(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	p.logger.Info("tunneling https request", "host", r.Host)
+
+	upstream, err := p.dialTunnel(r.Context(), r.Host)
+	if err != nil {
+		p.logger.Error("failed to connect to tunnel origin", "host", r.Host, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		p.logRelay(startTime, r, "TUNNEL", r.Host, http.StatusBadGateway, 0, 0)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		p.logger.Error("hijacking not supported")
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		p.logger.Error("failed to hijack connection", "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n")); err != nil {
+		p.logger.Error("failed to write 200 OK to client", "error", err)
+		return
+	}
+	p.tunnels.Add(1)
+
+	// Bytes the client sent before the tunnel was established are still in
+	// the hijacked reader
+	sent, received := p.relay(clientConn, clientBuf.Reader, upstream)
+	p.logger.Debug("tunnel closed", "host", r.Host, "sent", sent, "received", received)
+	p.logRelay(startTime, r, "TUNNEL", r.Host, http.StatusOK, received, sent)
+}
+
+// relay copies bytes between the client and upstream until both directions
+// are done, returning the number of bytes sent upstream and received from
+// it. Once upstream is done, the client has an idle timeout to finish.
+func (p *Proxy) relay(client net.Conn, clientReader io.Reader, upstream io.ReadWriteCloser) (sent, received int64) {
+	done := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(upstream, clientReader)
+		closeWrite(upstream)
+		done <- n
+	}()
+
+	received, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	if idleTimeout := p.config.Server.GetIdleTimeout(); idleTimeout > 0 {
+		client.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+	return <-done, received
+}
+
+// closeWrite shuts down the writing side of conn, so its peer sees the end of
+// the stream while replies can still be read, or closes conn if it cannot be
+// half-closed.
+func closeWrite(conn io.Closer) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// logRelay logs a tunnel or upgraded connection whose bytes were relayed
+// between the client and upstream. The size is the number of bytes sent to
+// the client, and the request size the number of bytes received from it.
+func (p *Proxy) logRelay(startTime time.Time, r *http.Request, cacheStatus, url string, statusCode int, size, requestSize int64) {
+	if p.accessLog == nil {
+		return
+	}
+	p.accessLog.Log(logging.AccessLogEntry{
+		Timestamp:   time.Now(),
+		CacheStatus: cacheStatus,
+		Status:      statusCode,
+		Method:      r.Method,
+		Size:        size,
+		Duration:    time.Since(startTime).Milliseconds(),
+		URL:         url,
+		RequestSize: requestSize,
+	})
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/logging"
+)
+
+// newOriginClient returns a client that trusts only server's own
+// certificate and sends its requests through p, so it fails if p intercepts
+// them.
+func newOriginClient(t *testing.T, p *Proxy, server *httptest.Server) *http.Client {
+	t.Helper()
+	proxyServer := httptest.NewServer(p)
+	t.Cleanup(proxyServer.Close)
+	proxyURL, _ := url.Parse(proxyServer.URL)
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+func TestSelectiveMITM(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("origin " + string(body)))
+	}))
+	defer server.Close()
+
+	t.Run("deny list tunnels", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		proxy.config.MITM.Hosts = []string{"127.0.0.*"}
+
+		logFile := filepath.Join(t.TempDir(), "access.log")
+		accessLog, err := logging.NewAccessLogger(logging.AccessLoggerConfig{
+			Format:     logging.FormatJSON,
+			LogFile:    logFile,
+			BufferSize: 10,
+		})
+		if err != nil {
+			t.Fatalf("failed to create access logger: %v", err)
+		}
+		proxy.accessLog = accessLog
+
+		client := newOriginClient(t, proxy, server)
+		for range 2 {
+			resp, err := client.Post(server.URL, "text/plain", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatalf("tunneled request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "origin hello" || resp.Header.Get("X-Cache") != "" {
+				t.Errorf("got X-Cache %q and %q, want the origin's response", resp.Header.Get("X-Cache"), body)
+			}
+		}
+		if proxy.GetTunnelCount() != 1 {
+			t.Errorf("got %d tunnels, want 1", proxy.GetTunnelCount())
+		}
+
+		// The tunnel is logged once both sides have closed it
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		deadline := time.Now().Add(5 * time.Second)
+		for accessLog.GetMetrics().EntriesLogged == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		accessLog.Close()
+
+		content, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("failed to read access log: %v", err)
+		}
+		var entry struct {
+			CacheStatus string `json:"cache_status"`
+			Status      int    `json:"status"`
+			Method      string `json:"method"`
+			Size        int64  `json:"size"`
+			URL         string `json:"url"`
+			RequestSize int64  `json:"request_size"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(string(content))), &entry); err != nil {
+			t.Fatalf("failed to parse access log entry %q: %v", content, err)
+		}
+		if entry.CacheStatus != "TUNNEL" || entry.Status != 200 || entry.Method != http.MethodConnect ||
+			entry.URL != strings.TrimPrefix(server.URL, "https://") || entry.Size == 0 || entry.RequestSize == 0 {
+			t.Errorf("got access log entry %+v, want a TUNNEL entry with byte counts", entry)
+		}
+	})
+
+	t.Run("allow list intercepts", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		proxy.config.MITM.Mode = "allow"
+		proxy.config.MITM.Hosts = []string{"127.0.0.1"}
+
+		resp, err := newMITMClient(t, proxy).Get(server.URL)
+		if err != nil {
+			t.Fatalf("intercepted request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get("X-Cache") != "MISS" {
+			t.Errorf("got X-Cache %q, want MISS for an allowed host", resp.Header.Get("X-Cache"))
+		}
+
+		proxy.config.MITM.Hosts = []string{"*.example.com"}
+		resp, err = newOriginClient(t, proxy, server).Get(server.URL)
+		if err != nil {
+			t.Fatalf("request for a host off the allow list failed: %v", err)
+		}
+		resp.Body.Close()
+		if proxy.GetTunnelCount() != 1 {
+			t.Errorf("got %d tunnels, want 1 for a host off the allow list", proxy.GetTunnelCount())
+		}
+	})
+
+	t.Run("fallback on rejected certificate", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		proxy.SetTransport(http.DefaultTransport)
+		proxy.config.MITM.FallbackOnReject = true
+		accessLog, err := logging.NewAccessLogger(logging.AccessLoggerConfig{
+			Format:     logging.FormatJSON,
+			LogFile:    filepath.Join(t.TempDir(), "access.log"),
+			BufferSize: 10,
+		})
+		if err != nil {
+			t.Fatalf("failed to create access logger: %v", err)
+		}
+		defer accessLog.Close()
+		proxy.accessLog = accessLog
+
+		client := newOriginClient(t, proxy, server)
+		if _, err := client.Get(server.URL); err == nil {
+			t.Fatal("expected the client to reject the proxy's certificate")
+		}
+
+		// The rejection is recorded once the proxy sees the client's alert
+		waitFor(t, func() bool { return proxy.GetCertRejectionCount() == 1 })
+		if hosts := proxy.GetRejectedHosts(); len(hosts) != 1 || hosts[0] != "127.0.0.1" {
+			t.Fatalf("got rejected hosts %q, want 127.0.0.1", hosts)
+		}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("expected the host to be tunneled after the rejection: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		// A reload intercepts the host again. The tunnel is logged once it has
+		// closed, so it no longer reads the old config
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		waitFor(t, func() bool { return accessLog.GetMetrics().EntriesLogged == 1 })
+		cfg := *proxy.config
+		cfg.MITM.FallbackOnReject = false
+		proxy.SetConfig(&cfg)
+		if hosts := proxy.GetRejectedHosts(); len(hosts) != 0 {
+			t.Fatalf("got rejected hosts %q after a reload, want none", hosts)
+		}
+		if _, err := newOriginClient(t, proxy, server).Get(server.URL); err == nil {
+			t.Fatal("expected the client to reject the proxy's certificate")
+		}
+		waitFor(t, func() bool { return proxy.GetCertRejectionCount() == 2 })
+		if hosts := proxy.GetRejectedHosts(); len(hosts) != 0 {
+			t.Errorf("got rejected hosts %q, want none with fallback_on_reject off", hosts)
+		}
+	})
+}
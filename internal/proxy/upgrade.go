@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// GetUpgradeCount returns the number of connections that switched protocols
+// and were relayed to upstream, such as WebSockets.
+func (p *Proxy) GetUpgradeCount() uint64 {
+	return p.upgrades.Load()
+}
+
+// isUpgrade reports whether r asks to switch protocols, as a WebSocket
+// handshake does. HTTP/2 has no Upgrade mechanism.
+func isUpgrade(r *http.Request) bool {
+	return r.ProtoMajor == 1 &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade") &&
+		r.Header.Get("Upgrade") != ""
+}
+
+// isWebSocket reports whether r is a WebSocket handshake.
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// handleUpgrade forwards a request to switch protocols and, if upstream
+// agrees, relays the raw stream between the client and upstream until both
+// sides are done. Upgrades are never cached. A response that does not switch
+// protocols is written to w; hijack returns the client connection and a
+// reader for the bytes it sends, and is only called after a switch.
+func (p *Proxy) handleUpgrade(w http.ResponseWriter, r *http.Request, hijack func() (net.Conn, io.Reader, error)) {
+	startTime := time.Now()
+	p.logger.Info("upgrade request", "url", r.URL.String(), "upgrade", r.Header.Get("Upgrade"))
+
+	r.RequestURI = ""
+	r.Header.Del("Proxy-Connection")
+	r.Header.Del("Proxy-Authorization")
+	capture := p.captureEnabled() && isWebSocket(r)
+	if capture {
+		// Compressed frames could not be read from the capture
+		r.Header.Del("Sec-WebSocket-Extensions")
+	}
+
+	resp, err := p.transport.RoundTrip(r)
+	if err != nil {
+		p.logger.Error("failed to forward upgrade request", "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		p.logRelay(startTime, r, "", r.URL.String(), http.StatusBadGateway, 0, 0)
+		return
+	}
+	defer resp.Body.Close()
+
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		p.logger.Debug("upstream declined upgrade", "url", r.URL.String(), "status", resp.StatusCode)
+		copyHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		n, _ := io.Copy(w, resp.Body)
+		p.logRelay(startTime, r, "", r.URL.String(), resp.StatusCode, n, 0)
+		return
+	}
+
+	client, clientReader, err := hijack()
+	if err != nil {
+		p.logger.Error("failed to hijack connection", "error", err)
+		return
+	}
+	defer client.Close()
+
+	fmt.Fprintf(client, "HTTP/1.1 %03d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	resp.Header.Write(client)
+	if _, err := io.WriteString(client, "\r\n"); err != nil {
+		p.logger.Error("failed to write upgrade response", "error", err)
+		return
+	}
+	p.upgrades.Add(1)
+
+	var fromUpstream io.Reader = upstream
+	if capture {
+		url := r.URL.String()
+		clientReader = io.TeeReader(clientReader, p.newFrameRecorder(url, "client"))
+		fromUpstream = io.TeeReader(upstream, p.newFrameRecorder(url, "server"))
+	}
+	sent, received := p.relay(client, clientReader, readWriteCloser{Reader: fromUpstream, WriteCloser: upstream})
+	p.logger.Debug("upgraded connection closed", "url", r.URL.String(), "sent", sent, "received", received)
+	p.logRelay(startTime, r, "", r.URL.String(), resp.StatusCode, received, sent)
+}
+
+// serveMITMUpgrade forwards an upgrade request read off the decrypted
+// connection of the CONNECT request r. It reports whether upstream declined
+// the upgrade and its response was written in full, so the connection can
+// carry another request.
+func (p *Proxy) serveMITMUpgrade(tlsConn net.Conn, reader io.Reader, r, req *http.Request) bool {
+	req.URL.Scheme = "https"
+	req.URL.Host = r.Host
+	req = p.takeControls(req)
+
+	cw := newConnResponseWriter(tlsConn, req)
+	switched := false
+	p.handleUpgrade(cw, req, func() (net.Conn, io.Reader, error) {
+		switched = true
+		return tlsConn, reader, nil
+	})
+	if switched {
+		return false
+	}
+	if err := cw.finish(); err != nil {
+		p.logger.Error("failed to write https response", "error", err)
+		return false
+	}
+	return true
+}
+
+// hijackerFor returns a hijack function for handleUpgrade that takes over
+// the connection behind w.
+func hijackerFor(w http.ResponseWriter) func() (net.Conn, io.Reader, error) {
+	return func() (net.Conn, io.Reader, error) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			return nil, nil, errors.New("hijacking not supported")
+		}
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, buf.Reader, nil
+	}
+}
+
+// readWriteCloser combines a reader with the writing and closing side of
+// another stream.
+type readWriteCloser struct {
+	io.Reader
+	io.WriteCloser
+}
@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeTestFrame writes a WebSocket frame, masked as clients send them if
+// mask is set.
+func writeTestFrame(w io.Writer, fin bool, opcode byte, payload []byte, mask bool) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	header := []byte{b0, 0}
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	data := payload
+	if mask {
+		key := []byte{0x12, 0x34, 0x56, 0x78}
+		header[1] |= 0x80
+		header = append(header, key...)
+		data = make([]byte, len(payload))
+		for i := range payload {
+			data[i] = payload[i] ^ key[i%4]
+		}
+	}
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
+// readTestFrame reads a WebSocket frame and unmasks its payload.
+func readTestFrame(r io.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	var key []byte
+	if header[1]&0x80 != 0 {
+		key = make([]byte, 4)
+		if _, err = io.ReadFull(r, key); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if key != nil {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return header[0]&0x80 != 0, header[0] & 0x0f, payload, nil
+}
+
+// newWebSocketServer returns a server that echoes the frames sent to /ws
+// after the WebSocket handshake, and answers other requests with a cacheable
+// body. extensions returns the Sec-WebSocket-Extensions of each handshake.
+func newWebSocketServer(tls bool) (server *httptest.Server, extensions func() []string) {
+	var mu sync.Mutex
+	var seen []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("plain"))
+			return
+		}
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Sec-WebSocket-Extensions"))
+		mu.Unlock()
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n")
+		for {
+			fin, opcode, payload, err := readTestFrame(buf.Reader)
+			if err != nil || writeTestFrame(conn, fin, opcode, payload, false) != nil || opcode == opClose {
+				return
+			}
+		}
+	})
+	if tls {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+// upgradeRequest is a WebSocket handshake for target, which is an absolute
+// URL for plain proxy requests or a path on an intercepted connection.
+func upgradeRequest(target, host string) string {
+	return "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n\r\n"
+}
+
+// exchangeFrames sends a text message, a binary message fragmented around a
+// ping, and a close frame over conn, and checks that each frame is echoed.
+func exchangeFrames(t *testing.T, conn io.Writer, reader *bufio.Reader) {
+	t.Helper()
+	frames := []struct {
+		fin     bool
+		opcode  byte
+		payload string
+	}{
+		{true, opText, "hello"},
+		{false, opBinary, "ab"},
+		{true, opPing, "p"},
+		{true, opContinuation, strings.Repeat("c", 70000)},
+		{true, opClose, "\x03\xe8"},
+	}
+	for _, f := range frames {
+		if err := writeTestFrame(conn, f.fin, f.opcode, []byte(f.payload), true); err != nil {
+			t.Fatalf("failed to write frame: %v", err)
+		}
+		fin, opcode, payload, err := readTestFrame(reader)
+		if err != nil {
+			t.Fatalf("failed to read echoed frame: %v", err)
+		}
+		if fin != f.fin || opcode != f.opcode || string(payload) != f.payload {
+			t.Errorf("got frame %d with %d bytes, want opcode %d with %d bytes", opcode, len(payload), f.opcode, len(f.payload))
+		}
+	}
+}
+
+// checkCapture checks that the capture file holds the messages sent by
+// exchangeFrames in both directions.
+func checkCapture(t *testing.T, path, url string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read capture file: %v", err)
+	}
+	got := map[string][]capturedMessage{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var m capturedMessage
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("failed to parse capture line %q: %v", line, err)
+		}
+		if m.URL != url {
+			t.Errorf("got capture URL %q, want %q", m.URL, url)
+		}
+		got[m.Direction] = append(got[m.Direction], m)
+	}
+
+	for _, direction := range []string{"client", "server"} {
+		messages := got[direction]
+		var summary []string
+		for _, m := range messages {
+			summary = append(summary, fmt.Sprintf("%s/%d/%q/%d/%v", m.Type, m.Size, m.Text, len(m.Data), m.Truncated))
+		}
+		want := []string{
+			`text/5/"hello"/0/false`,
+			`ping/1/""/1/false`,
+			fmt.Sprintf(`binary/70002/""/%d/true`, maxCapturedMessageBytes),
+			`close/2/""/2/false`,
+		}
+		if strings.Join(summary, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: got messages %v, want %v", direction, summary, want)
+		}
+		if len(messages) == 4 && !bytes.HasPrefix(messages[2].Data, []byte("abcc")) {
+			t.Errorf("%s: expected the fragments to be reassembled, got %q", direction, messages[2].Data[:4])
+		}
+	}
+}
+
+func TestUpgradePassthrough(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		captureFile := filepath.Join(t.TempDir(), "ws.jsonl")
+		proxy.config.WebSocket.CaptureFile = captureFile
+		defer proxy.Close()
+
+		server, extensions := newWebSocketServer(false)
+		defer server.Close()
+		proxyServer := httptest.NewServer(proxy)
+		defer proxyServer.Close()
+
+		conn, err := net.Dial("tcp", proxyServer.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		defer conn.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+		io.WriteString(conn, upgradeRequest(server.URL+"/ws", host))
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("failed to read handshake response: %v", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") == "" {
+			t.Fatalf("got status %d, want %d with the upstream handshake headers", resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+		exchangeFrames(t, conn, reader)
+
+		if got := extensions(); len(got) != 1 || got[0] != "" {
+			t.Errorf("got extensions %q, want them removed while capturing", got)
+		}
+		if proxy.GetUpgradeCount() != 1 {
+			t.Errorf("got %d upgrades, want 1", proxy.GetUpgradeCount())
+		}
+		if n := proxy.cache.GetStats().EntryCount; n != 0 {
+			t.Errorf("got %d cache entries, want none for an upgrade", n)
+		}
+		checkCapture(t, captureFile, server.URL+"/ws")
+	})
+
+	t.Run("https", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		captureFile := filepath.Join(t.TempDir(), "ws.jsonl")
+		proxy.config.WebSocket.CaptureFile = captureFile
+		defer proxy.Close()
+
+		server, _ := newWebSocketServer(true)
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "https://")
+
+		conn, reader := dialMITM(t, proxy, server.URL)
+		io.WriteString(conn, upgradeRequest("/ws", host))
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("failed to read handshake response: %v", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+		exchangeFrames(t, conn, reader)
+		checkCapture(t, captureFile, server.URL+"/ws")
+	})
+
+	t.Run("declined upgrade keeps the connection", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+
+		server, _ := newWebSocketServer(true)
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "https://")
+
+		conn, reader := dialMITM(t, proxy, server.URL)
+		requests := []struct{ request, xcache string }{
+			{upgradeRequest("/plain", host), ""},
+			{"GET /plain HTTP/1.1\r\nHost: " + host + "\r\n\r\n", "MISS"},
+			{"GET /plain HTTP/1.1\r\nHost: " + host + "\r\n\r\n", "HIT"},
+		}
+		for _, tt := range requests {
+			io.WriteString(conn, tt.request)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "plain" || resp.Header.Get("X-Cache") != tt.xcache {
+				t.Errorf("got %d, X-Cache %q and %q, want plain with X-Cache %q", resp.StatusCode, resp.Header.Get("X-Cache"), body, tt.xcache)
+			}
+		}
+		if proxy.GetUpgradeCount() != 0 {
+			t.Errorf("got %d upgrades, want none", proxy.GetUpgradeCount())
+		}
+	})
+}
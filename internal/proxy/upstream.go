@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// newTransport returns the transport for requests sent upstream, which
+// routes each request through the parent proxy chosen by [upstream].
+func (p *Proxy) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return p.parentProxy(req.URL)
+	}
+	return transport
+}
+
+// parentProxy returns the parent proxy for requests to target, or nil to
+// connect directly. Without an [upstream] setting for the host, the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+func (p *Proxy) parentProxy(target *url.URL) (*url.URL, error) {
+	switch route := p.config.Upstream.Route(target.Hostname()); route {
+	case "direct":
+		return nil, nil
+	case "":
+		return http.ProxyFromEnvironment(&http.Request{URL: target})
+	default:
+		return url.Parse(route)
+	}
+}
+
+// dialTunnel opens a connection to the origin of a tunneled CONNECT request,
+// through the parent proxy for its host if there is one.
+func (p *Proxy) dialTunnel(ctx context.Context, addr string) (net.Conn, error) {
+	parent, err := p.parentProxy(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: tunnelDialTimeout}
+	if parent == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	p.logger.Debug("tunneling through parent proxy", "host", addr, "proxy", parent.Redacted())
+
+	switch parent.Scheme {
+	case "socks5", "socks5h":
+		socks, err := proxy.FromURL(parent, dialer)
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := socks.(proxy.ContextDialer); ok {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+		return socks.Dial("tcp", addr)
+	default:
+		return dialConnect(ctx, dialer, parent, addr)
+	}
+}
+
+// dialConnect opens a tunnel to addr through the HTTP or HTTPS proxy parent
+// with a CONNECT request.
+func dialConnect(ctx context.Context, dialer *net.Dialer, parent *url.URL, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr(parent))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(tunnelDialTimeout))
+	if parent.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: parent.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if parent.User != nil {
+		password, _ := parent.User.Password()
+		credentials := parent.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The body of a successful CONNECT response is the tunnel itself, so it
+	// is not closed
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("parent proxy %s refused CONNECT: %s", parent.Host, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// proxyAddr returns the host and port of a parent proxy, with the default
+// port for its scheme if the URL has none.
+func proxyAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	switch u.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// bufferedConn is a connection whose first bytes were already read into
+// reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite half-closes the underlying connection, so relay can signal the
+// end of the client's stream.
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
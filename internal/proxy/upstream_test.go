@@ -0,0 +1,325 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gbmerrall/gocache/internal/config"
+)
+
+// testParent is a stand-in parent proxy that records the requests it
+// relays.
+type testParent struct {
+	url string
+
+	mu       sync.Mutex
+	requests []string // Method and host, or "SOCKS" and address
+	auth     []string // Proxy-Authorization, or SOCKS user:password
+}
+
+func (tp *testParent) record(request, auth string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.requests = append(tp.requests, request)
+	tp.auth = append(tp.auth, auth)
+}
+
+func (tp *testParent) seen() ([]string, []string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return append([]string(nil), tp.requests...), append([]string(nil), tp.auth...)
+}
+
+// newHTTPParent starts an HTTP parent proxy. If credentials is set, requests
+// without it are refused with 407.
+func newHTTPParent(t *testing.T, credentials string) *testParent {
+	t.Helper()
+	tp := &testParent{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Proxy-Authorization")
+		tp.record(r.Method+" "+r.Host, auth)
+		if credentials != "" && auth != "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		if r.Method != http.MethodConnect {
+			r.RequestURI = ""
+			r.Header.Del("Proxy-Authorization")
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			copyHeaders(w.Header(), resp.Header)
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		pipe(conn, buf.Reader, upstream)
+	}))
+	t.Cleanup(server.Close)
+	tp.url = server.URL
+	return tp
+}
+
+// newSOCKSParent starts a SOCKS5 parent proxy that requires the username
+// and password authentication method.
+func newSOCKSParent(t *testing.T) *testParent {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	tp := &testParent{url: "socks5://" + listener.Addr().String()}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go tp.serveSOCKS(conn)
+		}
+	}()
+	return tp
+}
+
+func (tp *testParent) serveSOCKS(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	readN := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	// Greeting, then username and password (RFC 1928, RFC 1929)
+	head := readN(2)
+	if head == nil || readN(int(head[1])) == nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	ver := readN(2)
+	if ver == nil {
+		return
+	}
+	user := readN(int(ver[1]))
+	plen := readN(1)
+	if user == nil || plen == nil {
+		return
+	}
+	password := readN(int(plen[0]))
+	conn.Write([]byte{1, 0})
+
+	request := readN(4)
+	if request == nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		host = net.IP(readN(4)).String()
+	case 3:
+		host = string(readN(int(readN(1)[0])))
+	case 4:
+		host = net.IP(readN(16)).String()
+	}
+	port := binary.BigEndian.Uint16(readN(2))
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	tp.record("SOCKS "+addr, string(user)+":"+string(password))
+
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, r, upstream)
+}
+
+// pipe copies bytes between conn and upstream until both are done.
+func pipe(conn net.Conn, reader io.Reader, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, reader)
+		closeWrite(upstream)
+		close(done)
+	}()
+	io.Copy(conn, upstream)
+	closeWrite(conn)
+	<-done
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("origin " + r.URL.Path))
+	}))
+	defer origin.Close()
+	tlsOrigin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("secure " + r.URL.Path))
+	}))
+	defer tlsOrigin.Close()
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+	tlsOriginHost := strings.TrimPrefix(tlsOrigin.URL, "https://")
+
+	newPlainClient := func(p *Proxy) *http.Client {
+		proxyServer := httptest.NewServer(p)
+		t.Cleanup(proxyServer.Close)
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	}
+	get := func(t *testing.T, client *http.Client, target string) (string, string) {
+		t.Helper()
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("request for %s failed: %v", target, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header.Get("X-Cache")
+	}
+
+	t.Run("http requests use the parent with credentials", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		parent := newHTTPParent(t, "user:secret")
+		proxy.config.Upstream.Proxy = strings.Replace(parent.url, "http://", "http://user:secret@", 1)
+
+		client := newPlainClient(proxy)
+		for _, want := range []string{"MISS", "HIT"} {
+			if body, xcache := get(t, client, origin.URL+"/a"); body != "origin /a" || xcache != want {
+				t.Errorf("got %q with X-Cache %q, want the origin's body with %s", body, xcache, want)
+			}
+		}
+		requests, auth := parent.seen()
+		if len(requests) != 1 || requests[0] != "GET "+originHost {
+			t.Errorf("got parent requests %q, want one GET for %s", requests, originHost)
+		}
+		if len(auth) == 1 && auth[0] != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")) {
+			t.Errorf("got Proxy-Authorization %q, want the configured credentials", auth[0])
+		}
+	})
+
+	t.Run("no_proxy and routes", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		parent := newHTTPParent(t, "")
+		other := newHTTPParent(t, "")
+		proxy.config.Upstream = config.UpstreamConfig{
+			Proxy:   parent.url,
+			NoProxy: []string{"127.0.0.0/8"},
+			Routes:  []config.UpstreamRoute{{Hosts: []string{"localhost"}, Proxy: other.url}},
+		}
+
+		client := newPlainClient(proxy)
+		get(t, client, origin.URL+"/direct")
+		localURL := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1)
+		get(t, client, localURL+"/routed")
+
+		if requests, _ := parent.seen(); len(requests) != 0 {
+			t.Errorf("got parent requests %q, want none for a no_proxy host", requests)
+		}
+		if requests, _ := other.seen(); len(requests) != 1 || !strings.HasPrefix(requests[0], "GET localhost:") {
+			t.Errorf("got routed parent requests %q, want one for localhost", requests)
+		}
+
+		proxy.config.Upstream.Routes = []config.UpstreamRoute{{Hosts: []string{"localhost"}, Proxy: "direct"}}
+		proxy.config.Upstream.NoProxy = nil
+		get(t, client, localURL+"/direct-route")
+		get(t, client, origin.URL+"/parent")
+		if requests, _ := parent.seen(); len(requests) != 1 || requests[0] != "GET "+originHost {
+			t.Errorf("got parent requests %q, want only the one for %s", requests, originHost)
+		}
+	})
+
+	t.Run("intercepted https connects through the parent", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		parent := newHTTPParent(t, "")
+		proxy.config.Upstream.Proxy = parent.url
+
+		client := newMITMClient(t, proxy)
+		transport := proxy.newTransport()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		proxy.SetTransport(transport)
+
+		if body, xcache := get(t, client, tlsOrigin.URL+"/s"); body != "secure /s" || xcache != "MISS" {
+			t.Errorf("got %q with X-Cache %q, want the origin's body with MISS", body, xcache)
+		}
+		if requests, _ := parent.seen(); len(requests) != 1 || requests[0] != "CONNECT "+tlsOriginHost {
+			t.Errorf("got parent requests %q, want a CONNECT for %s", requests, tlsOriginHost)
+		}
+	})
+
+	for _, kind := range []string{"http", "socks5"} {
+		t.Run("tunnels through an "+kind+" parent", func(t *testing.T) {
+			proxy, cleanup := setupTestProxy(t)
+			defer cleanup()
+			proxy.config.MITM.Hosts = []string{"127.0.0.*"}
+
+			var parent *testParent
+			var want, wantAuth string
+			if kind == "http" {
+				parent = newHTTPParent(t, "user:secret")
+				want = "CONNECT " + tlsOriginHost
+				wantAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+			} else {
+				parent = newSOCKSParent(t)
+				want = "SOCKS " + tlsOriginHost
+				wantAuth = "user:secret"
+			}
+			proxy.config.Upstream.Proxy = strings.Replace(parent.url, "://", "://user:secret@", 1)
+
+			if body, _ := get(t, newOriginClient(t, proxy, tlsOrigin), tlsOrigin.URL+"/t"); body != "secure /t" {
+				t.Errorf("got %q, want the origin's body", body)
+			}
+			requests, auth := parent.seen()
+			if len(requests) != 1 || requests[0] != want || auth[0] != wantAuth {
+				t.Errorf("got parent requests %q with credentials %q, want %q with %q", requests, auth, want, wantAuth)
+			}
+		})
+	}
+
+	t.Run("tunnel refused by the parent", func(t *testing.T) {
+		proxy, cleanup := setupTestProxy(t)
+		defer cleanup()
+		proxy.config.MITM.Hosts = []string{"127.0.0.*"}
+		proxy.config.Upstream.Proxy = newHTTPParent(t, "user:secret").url
+
+		if _, err := newOriginClient(t, proxy, tlsOrigin).Get(tlsOrigin.URL); err == nil {
+			t.Error("expected the tunnel to fail without the parent's credentials")
+		}
+	})
+}
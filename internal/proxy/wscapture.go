@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// maxCapturedMessageBytes bounds how much of each WebSocket message is
+// written to the capture file. Longer messages are truncated.
+const maxCapturedMessageBytes = 64 << 10
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// capturedMessage is one line of the WebSocket capture file.
+type capturedMessage struct {
+	Timestamp time.Time `json:"timestamp"`
+	URL       string    `json:"url"`
+	Direction string    `json:"direction"` // "client" or "server"
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	Text      string    `json:"text,omitempty"`
+	Data      []byte    `json:"data,omitempty"` // Base64 in the file
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// captureEnabled reports whether WebSocket messages are written to a capture
+// file.
+func (p *Proxy) captureEnabled() bool {
+	return p.config.WebSocket.CaptureFile != ""
+}
+
+// writeCapture appends m to the capture file, opening it on first use.
+func (p *Proxy) writeCapture(m capturedMessage) {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	p.captureMu.Lock()
+	defer p.captureMu.Unlock()
+	if p.captureFile == nil {
+		f, err := os.OpenFile(p.config.WebSocket.CaptureFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			p.logger.Error("failed to open websocket capture file", "path", p.config.WebSocket.CaptureFile, "error", err)
+			return
+		}
+		p.captureFile = f
+	}
+	if _, err := p.captureFile.Write(line); err != nil {
+		p.logger.Error("failed to write websocket capture", "error", err)
+	}
+}
+
+// closeCapture closes the capture file if it was opened.
+func (p *Proxy) closeCapture() {
+	p.captureMu.Lock()
+	defer p.captureMu.Unlock()
+	if p.captureFile != nil {
+		p.captureFile.Close()
+		p.captureFile = nil
+	}
+}
+
+// frameRecorder parses the WebSocket frames written to it, one direction of
+// a connection, and captures each message once its last fragment arrives.
+// Control frames are captured as they arrive, even between fragments. It
+// never fails, so it can sit in a relay: on a malformed stream it logs once
+// and stops parsing.
+type frameRecorder struct {
+	p         *Proxy
+	url       string
+	direction string
+
+	header    []byte // Header of the frame being read
+	remaining uint64 // Payload bytes left in the current frame
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	opcode    byte
+	fin       bool
+	control   []byte // Payload of the current control frame
+
+	inMessage   bool
+	messageType byte
+	message     []byte
+	messageSize int64
+	truncated   bool
+
+	broken bool
+}
+
+// newFrameRecorder returns a writer that captures the WebSocket messages
+// sent in one direction of the connection to url.
+func (p *Proxy) newFrameRecorder(url, direction string) io.Writer {
+	return &frameRecorder{p: p, url: url, direction: direction}
+}
+
+func (fr *frameRecorder) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 && !fr.broken {
+		if fr.remaining == 0 {
+			need := frameHeaderLen(fr.header)
+			take := min(need-len(fr.header), len(b))
+			fr.header = append(fr.header, b[:take]...)
+			b = b[take:]
+			if len(fr.header) < frameHeaderLen(fr.header) {
+				continue
+			}
+			fr.startFrame()
+			if fr.remaining == 0 && !fr.broken {
+				fr.endFrame()
+			}
+			continue
+		}
+
+		take := uint64(len(b))
+		if take > fr.remaining {
+			take = fr.remaining
+		}
+		fr.appendPayload(b[:take])
+		b = b[take:]
+		fr.remaining -= take
+		if fr.remaining == 0 {
+			fr.endFrame()
+		}
+	}
+	return n, nil
+}
+
+// frameHeaderLen returns the length of the frame header starting with h,
+// which grows as the length and mask bits are read.
+func frameHeaderLen(h []byte) int {
+	if len(h) < 2 {
+		return 2
+	}
+	n := 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+	return n
+}
+
+// startFrame decodes the complete header of the next frame.
+func (fr *frameRecorder) startFrame() {
+	h := fr.header
+	fr.header = fr.header[:0]
+	fr.fin = h[0]&0x80 != 0
+	fr.opcode = h[0] & 0x0f
+	fr.masked = h[1]&0x80 != 0
+	fr.maskPos = 0
+
+	var length uint64
+	switch l := h[1] & 0x7f; l {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(h[2:10])
+	default:
+		length = uint64(l)
+	}
+	if fr.masked {
+		copy(fr.mask[:], h[len(h)-4:])
+	}
+
+	switch {
+	case fr.opcode >= opClose:
+		if fr.opcode > opPong || !fr.fin || length > 125 {
+			fr.fail("invalid control frame")
+			return
+		}
+		fr.control = fr.control[:0]
+	case fr.opcode == opContinuation:
+		if !fr.inMessage {
+			fr.fail("continuation frame outside a message")
+			return
+		}
+	case fr.opcode == opText || fr.opcode == opBinary:
+		if fr.inMessage {
+			fr.fail("new message before the last one finished")
+			return
+		}
+		fr.inMessage = true
+		fr.messageType = fr.opcode
+		fr.message = fr.message[:0]
+		fr.messageSize = 0
+		fr.truncated = false
+	default:
+		fr.fail("reserved opcode")
+		return
+	}
+	fr.remaining = length
+}
+
+// appendPayload unmasks b and adds it to the current frame's message.
+func (fr *frameRecorder) appendPayload(b []byte) {
+	start := fr.maskPos
+	fr.maskPos = (start + len(b)) % 4
+	if fr.opcode < opClose {
+		fr.messageSize += int64(len(b))
+		if room := maxCapturedMessageBytes - len(fr.message); len(b) > room {
+			b = b[:room]
+			fr.truncated = true
+		}
+	}
+	data := make([]byte, len(b))
+	for i, c := range b {
+		if fr.masked {
+			c ^= fr.mask[(start+i)%4]
+		}
+		data[i] = c
+	}
+	if fr.opcode >= opClose {
+		fr.control = append(fr.control, data...)
+	} else {
+		fr.message = append(fr.message, data...)
+	}
+}
+
+// endFrame captures the message that the current frame completes, if any.
+func (fr *frameRecorder) endFrame() {
+	if fr.opcode >= opClose {
+		fr.capture(fr.opcode, fr.control, int64(len(fr.control)), false)
+		return
+	}
+	if fr.fin {
+		fr.inMessage = false
+		fr.capture(fr.messageType, fr.message, fr.messageSize, fr.truncated)
+	}
+}
+
+func (fr *frameRecorder) capture(opcode byte, payload []byte, size int64, truncated bool) {
+	m := capturedMessage{
+		Timestamp: time.Now(),
+		URL:       fr.url,
+		Direction: fr.direction,
+		Size:      size,
+		Truncated: truncated,
+	}
+	switch opcode {
+	case opText:
+		m.Type = "text"
+		m.Text = string(payload)
+	case opBinary:
+		m.Type = "binary"
+	case opClose:
+		m.Type = "close"
+	case opPing:
+		m.Type = "ping"
+	case opPong:
+		m.Type = "pong"
+	}
+	if opcode != opText && len(payload) > 0 {
+		m.Data = append([]byte(nil), payload...)
+	}
+	fr.p.writeCapture(m)
+}
+
+func (fr *frameRecorder) fail(reason string) {
+	fr.broken = true
+	fr.p.logger.Debug("stopped capturing websocket frames", "url", fr.url, "direction", fr.direction, "reason", reason)
+}
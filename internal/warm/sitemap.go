@@ -0,0 +1,72 @@
+package warm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sitemapLoc is a <url> or <sitemap> element; only the location is used.
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapDoc covers both <urlset> and <sitemapindex> documents.
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+// ParseSitemap parses a sitemap or sitemap index, optionally gzip compressed.
+// It returns the page URLs of a <urlset> and the child sitemap URLs of a <sitemapindex>.
+func ParseSitemap(data []byte) (urls []string, sitemaps []string, err error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzipped sitemap: %w", err)
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzipped sitemap: %w", err)
+		}
+	}
+
+	var doc sitemapDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid sitemap: %w", err)
+	}
+
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				urls = append(urls, loc)
+			}
+		}
+	case "sitemapindex":
+		for _, s := range doc.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				sitemaps = append(sitemaps, loc)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid sitemap: unexpected root element <%s>", doc.XMLName.Local)
+	}
+	return urls, sitemaps, nil
+}
+
+// IsSitemap reports whether data looks like a sitemap or sitemap index document.
+func IsSitemap(data []byte) bool {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return true
+	}
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return bytes.Contains(head, []byte("<urlset")) || bytes.Contains(head, []byte("<sitemapindex"))
+}
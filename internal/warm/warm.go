@@ -0,0 +1,428 @@
+// Package warm fetches lists of URLs through the proxy so their responses land in the cache.
+package warm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/proxy"
+)
+
+// maxSitemapDepth bounds how deeply nested sitemap indexes are followed.
+const maxSitemapDepth = 3
+
+// maxFinishedJobs is how many finished jobs a Manager keeps for status
+// queries. Older finished jobs are forgotten.
+const maxFinishedJobs = 20
+
+// Job states.
+const (
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateCancelled = "cancelled"
+)
+
+// Fetcher performs a GET through the proxy's cache path. *proxy.Proxy implements it.
+type Fetcher interface {
+	Fetch(ctx context.Context, namespace, rawURL string, body io.Writer) (proxy.FetchResult, error)
+}
+
+// Options controls how a warm job fetches URLs.
+type Options struct {
+	Concurrency int     // Number of concurrent fetches; values below 1 mean 1
+	RatePerHost float64 // Maximum requests per second to any one host; 0 means unlimited
+}
+
+// Request describes what a warm job should fetch.
+type Request struct {
+	Namespace string // Cache namespace to fill; empty means the default
+	URLs      []string
+	Sitemaps  []string
+	Options   Options
+}
+
+// Failure records a URL that could not be warmed.
+type Failure struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error"`
+}
+
+// Status is a point-in-time snapshot of a job's progress.
+type Status struct {
+	ID         string    `json:"id"`
+	Namespace  string    `json:"namespace,omitempty"`
+	State      string    `json:"state"`
+	Total      int       `json:"total"`
+	Done       int       `json:"done"`
+	Succeeded  int       `json:"succeeded"`
+	Failed     int       `json:"failed"`
+	Hits       int       `json:"already_cached"`
+	Failures   []Failure `json:"failures"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Elapsed    string    `json:"elapsed"`
+}
+
+// Job is a warm run executing in the background.
+type Job struct {
+	id     string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+// ID returns the job's identifier.
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Status returns a snapshot of the job's progress.
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := j.status
+	s.Failures = append([]Failure{}, j.status.Failures...)
+	end := s.FinishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	s.Elapsed = end.Sub(s.StartedAt).Round(time.Millisecond).String()
+	return s
+}
+
+// Wait blocks until the job finishes.
+func (j *Job) Wait() {
+	<-j.done
+}
+
+// Cancel stops the job. URLs already being fetched are abandoned.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) recordFailure(f Failure) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Done++
+	j.status.Failed++
+	j.status.Failures = append(j.status.Failures, f)
+}
+
+func (j *Job) recordSuccess(cacheStatus string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Done++
+	j.status.Succeeded++
+	if cacheStatus == "HIT" {
+		j.status.Hits++
+	}
+}
+
+// Manager starts warm jobs and keeps track of them.
+type Manager struct {
+	logger  *slog.Logger
+	fetcher Fetcher
+
+	mu           sync.Mutex
+	jobs         map[string]*Job
+	nextID       int
+	keepFinished int // Finished jobs kept for status queries
+}
+
+// NewManager creates a Manager that fetches through fetcher.
+func NewManager(logger *slog.Logger, fetcher Fetcher) *Manager {
+	return &Manager{
+		logger:  logger,
+		fetcher: fetcher,
+		jobs:    make(map[string]*Job),
+
+		keepFinished: maxFinishedJobs,
+	}
+}
+
+// Start begins a warm job in the background and returns immediately.
+func (m *Manager) Start(req Request) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m.mu.Lock()
+	m.nextID++
+	id := strconv.Itoa(m.nextID)
+	job := &Job{
+		id:     id,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: Status{
+			ID:        id,
+			Namespace: req.Namespace,
+			State:     StateRunning,
+			Failures:  []Failure{},
+			StartedAt: time.Now(),
+		},
+	}
+	m.jobs[id] = job
+	m.mu.Unlock()
+
+	go m.run(ctx, job, req)
+	return job
+}
+
+// Get returns the job with the given ID.
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List returns the status of every job, oldest first.
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.Unlock()
+
+	statuses := make([]Status, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, job.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, _ := strconv.Atoi(statuses[i].ID)
+		b, _ := strconv.Atoi(statuses[j].ID)
+		return a < b
+	})
+	return statuses
+}
+
+// Shutdown cancels all running jobs and waits for them to stop.
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.Unlock()
+
+	for _, job := range jobs {
+		job.Cancel()
+		job.Wait()
+	}
+}
+
+func (m *Manager) run(ctx context.Context, job *Job, req Request) {
+	defer close(job.done)
+	defer job.cancel()
+
+	urls := m.collectURLs(ctx, job, req)
+
+	job.mu.Lock()
+	job.status.Total = len(urls) + job.status.Failed // Failed sitemaps count towards the total
+	job.mu.Unlock()
+	m.logger.Info("warm job started", "id", job.id, "urls", len(urls), "concurrency", req.Options.Concurrency)
+
+	concurrency := req.Options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	limiter := newHostLimiter(req.Options.RatePerHost)
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range work {
+				m.warmURL(ctx, job, limiter, req.Namespace, u)
+			}
+		}()
+	}
+
+feed:
+	for _, u := range urls {
+		select {
+		case work <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	job.mu.Lock()
+	job.status.FinishedAt = time.Now()
+	if ctx.Err() != nil {
+		job.status.State = StateCancelled
+	} else {
+		job.status.State = StateCompleted
+	}
+	job.mu.Unlock()
+
+	s := job.Status()
+	m.logger.Info("warm job finished", "id", job.id, "state", s.State, "succeeded", s.Succeeded, "failed", s.Failed, "elapsed", s.Elapsed)
+	m.pruneFinished()
+}
+
+// pruneFinished forgets the oldest finished jobs beyond keepFinished.
+// Running jobs are always kept.
+func (m *Manager) pruneFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var finished []int
+	for id, job := range m.jobs {
+		job.mu.Lock()
+		running := job.status.State == StateRunning
+		job.mu.Unlock()
+		if !running {
+			n, _ := strconv.Atoi(id)
+			finished = append(finished, n)
+		}
+	}
+	if len(finished) <= m.keepFinished {
+		return
+	}
+	sort.Ints(finished)
+	for _, n := range finished[:len(finished)-m.keepFinished] {
+		delete(m.jobs, strconv.Itoa(n))
+	}
+}
+
+// collectURLs expands sitemaps and returns the de-duplicated list of URLs to warm.
+// Sitemaps that cannot be fetched or parsed are recorded as failures.
+func (m *Manager) collectURLs(ctx context.Context, job *Job, req Request) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(u string) {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, u := range req.URLs {
+		add(u)
+	}
+
+	visited := make(map[string]bool)
+	var expand func(sitemapURL string, depth int)
+	expand = func(sitemapURL string, depth int) {
+		if visited[sitemapURL] || ctx.Err() != nil {
+			return
+		}
+		visited[sitemapURL] = true
+		if depth > maxSitemapDepth {
+			job.recordFailure(Failure{URL: sitemapURL, Error: "sitemap index nested too deeply"})
+			return
+		}
+
+		var buf bytes.Buffer
+		result, err := m.fetcher.Fetch(ctx, req.Namespace, sitemapURL, &buf)
+		if err != nil {
+			job.recordFailure(Failure{URL: sitemapURL, Error: err.Error()})
+			return
+		}
+		if result.StatusCode >= 400 {
+			job.recordFailure(Failure{URL: sitemapURL, StatusCode: result.StatusCode, Error: "sitemap fetch failed"})
+			return
+		}
+		pages, children, err := ParseSitemap(buf.Bytes())
+		if err != nil {
+			job.recordFailure(Failure{URL: sitemapURL, Error: err.Error()})
+			return
+		}
+		m.logger.Debug("sitemap expanded", "url", sitemapURL, "urls", len(pages), "sitemaps", len(children))
+		for _, u := range pages {
+			add(u)
+		}
+		for _, child := range children {
+			expand(child, depth+1)
+		}
+	}
+	for _, s := range req.Sitemaps {
+		expand(s, 0)
+	}
+	return urls
+}
+
+func (m *Manager) warmURL(ctx context.Context, job *Job, limiter *hostLimiter, namespace, rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		job.recordFailure(Failure{URL: rawURL, Error: "invalid URL"})
+		return
+	}
+	if err := limiter.wait(ctx, u.Host); err != nil {
+		return
+	}
+
+	result, err := m.fetcher.Fetch(ctx, namespace, rawURL, nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			job.recordFailure(Failure{URL: rawURL, Error: err.Error()})
+		}
+		return
+	}
+	if result.StatusCode >= 400 {
+		job.recordFailure(Failure{URL: rawURL, StatusCode: result.StatusCode, Error: http.StatusText(result.StatusCode)})
+		return
+	}
+	m.logger.Debug("url warmed", "url", rawURL, "status", result.StatusCode, "cacheStatus", result.CacheStatus)
+	job.recordSuccess(result.CacheStatus)
+}
+
+// hostLimiter spaces out requests to each host to honor a per-host rate.
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(ratePerHost float64) *hostLimiter {
+	l := &hostLimiter{next: make(map[string]time.Time)}
+	if ratePerHost > 0 {
+		l.interval = time.Duration(float64(time.Second) / ratePerHost)
+	}
+	return l
+}
+
+// wait blocks until host may be requested again, or ctx is done.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
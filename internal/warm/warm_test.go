@@ -0,0 +1,241 @@
+package warm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbmerrall/gocache/internal/proxy"
+)
+
+// fakeFetcher serves canned bodies and records the order of requests.
+type fakeFetcher struct {
+	mu       sync.Mutex
+	bodies   map[string]string
+	statuses map[string]int
+	errs     map[string]error
+	requests []string
+	times    []time.Time
+	delay    time.Duration
+}
+
+func (f *fakeFetcher) Fetch(ctx context.Context, namespace, rawURL string, body io.Writer) (proxy.FetchResult, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, rawURL)
+	f.times = append(f.times, time.Now())
+	f.mu.Unlock()
+
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return proxy.FetchResult{}, ctx.Err()
+		}
+	}
+	if err := f.errs[rawURL]; err != nil {
+		return proxy.FetchResult{}, err
+	}
+	status := http.StatusOK
+	if s, ok := f.statuses[rawURL]; ok {
+		status = s
+	}
+	if body != nil {
+		io.WriteString(body, f.bodies[rawURL])
+	}
+	return proxy.FetchResult{StatusCode: status, CacheStatus: "MISS"}, nil
+}
+
+func newTestManager(f Fetcher) *Manager {
+	return NewManager(slog.New(slog.NewTextHandler(io.Discard, nil)), f)
+}
+
+func TestParseSitemap(t *testing.T) {
+	t.Run("urlset", func(t *testing.T) {
+		urls, sitemaps, err := ParseSitemap([]byte(`<?xml version="1.0"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> http://a.test/1 </loc></url>
+  <url><loc>http://a.test/2</loc><lastmod>2024-01-01</lastmod></url>
+</urlset>`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(urls) != 2 || urls[0] != "http://a.test/1" || len(sitemaps) != 0 {
+			t.Errorf("got urls %v sitemaps %v", urls, sitemaps)
+		}
+	})
+
+	t.Run("sitemap index", func(t *testing.T) {
+		urls, sitemaps, err := ParseSitemap([]byte(`<sitemapindex><sitemap><loc>http://a.test/s1.xml</loc></sitemap></sitemapindex>`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(urls) != 0 || len(sitemaps) != 1 || sitemaps[0] != "http://a.test/s1.xml" {
+			t.Errorf("got urls %v sitemaps %v", urls, sitemaps)
+		}
+	})
+
+	t.Run("gzipped", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(`<urlset><url><loc>http://a.test/z</loc></url></urlset>`))
+		zw.Close()
+		if !IsSitemap(buf.Bytes()) {
+			t.Error("expected gzipped data to be detected as a sitemap")
+		}
+		urls, _, err := ParseSitemap(buf.Bytes())
+		if err != nil || len(urls) != 1 {
+			t.Errorf("got urls %v, err %v", urls, err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, _, err := ParseSitemap([]byte("not xml")); err == nil {
+			t.Error("expected error for invalid XML")
+		}
+		if _, _, err := ParseSitemap([]byte("<html></html>")); err == nil {
+			t.Error("expected error for unexpected root element")
+		}
+		if IsSitemap([]byte("http://a.test/\nhttp://b.test/")) {
+			t.Error("plain URL list detected as sitemap")
+		}
+	})
+}
+
+func TestManagerWarmsURLsAndSitemaps(t *testing.T) {
+	f := &fakeFetcher{
+		bodies: map[string]string{
+			"http://a.test/index.xml": `<sitemapindex><sitemap><loc>http://a.test/pages.xml</loc></sitemap><sitemap><loc>http://a.test/missing.xml</loc></sitemap></sitemapindex>`,
+			"http://a.test/pages.xml": `<urlset><url><loc>http://a.test/1</loc></url><url><loc>http://a.test/2</loc></url></urlset>`,
+		},
+		statuses: map[string]int{
+			"http://a.test/missing.xml": http.StatusNotFound,
+			"http://a.test/gone":        http.StatusGone,
+		},
+		errs: map[string]error{
+			"http://a.test/broken": errors.New("connection refused"),
+		},
+	}
+	m := newTestManager(f)
+
+	job := m.Start(Request{
+		URLs:     []string{"http://a.test/1", "http://a.test/gone", "http://a.test/broken", "not-a-url"},
+		Sitemaps: []string{"http://a.test/index.xml"},
+		Options:  Options{Concurrency: 3},
+	})
+	job.Wait()
+
+	s := job.Status()
+	if s.State != StateCompleted {
+		t.Errorf("got state %q, want %q", s.State, StateCompleted)
+	}
+	// 5 unique URLs (1 duplicated by the sitemap) plus one failed sitemap.
+	if s.Total != 6 {
+		t.Errorf("got total %d, want 6", s.Total)
+	}
+	if s.Done != s.Total {
+		t.Errorf("got done %d, want %d", s.Done, s.Total)
+	}
+	if s.Succeeded != 2 {
+		t.Errorf("got succeeded %d, want 2", s.Succeeded)
+	}
+	if s.Failed != 4 || len(s.Failures) != 4 {
+		t.Errorf("got %d failed with %d failures, want 4", s.Failed, len(s.Failures))
+	}
+	if s.FinishedAt.IsZero() {
+		t.Error("expected finished time to be set")
+	}
+
+	if got, ok := m.Get(job.ID()); !ok || got != job {
+		t.Error("expected job to be retrievable by ID")
+	}
+	if list := m.List(); len(list) != 1 || list[0].ID != job.ID() {
+		t.Errorf("unexpected job list: %v", list)
+	}
+}
+
+func TestManagerRateLimitsPerHost(t *testing.T) {
+	f := &fakeFetcher{}
+	m := newTestManager(f)
+
+	job := m.Start(Request{
+		URLs:    []string{"http://a.test/1", "http://a.test/2", "http://a.test/3", "http://b.test/1"},
+		Options: Options{Concurrency: 4, RatePerHost: 20}, // 50ms between requests to a host
+	})
+	job.Wait()
+
+	var aTimes []time.Time
+	for i, u := range f.requests {
+		if u[:13] == "http://a.test" {
+			aTimes = append(aTimes, f.times[i])
+		}
+	}
+	if len(aTimes) != 3 {
+		t.Fatalf("expected 3 requests to a.test, got %d", len(aTimes))
+	}
+	first, last := aTimes[0], aTimes[0]
+	for _, tm := range aTimes {
+		if tm.Before(first) {
+			first = tm
+		}
+		if tm.After(last) {
+			last = tm
+		}
+	}
+	if spread := last.Sub(first); spread < 90*time.Millisecond {
+		t.Errorf("requests to one host spread over %v, want at least ~100ms", spread)
+	}
+}
+
+func TestManagerShutdownCancelsJobs(t *testing.T) {
+	f := &fakeFetcher{delay: time.Second}
+	m := newTestManager(f)
+
+	urls := make([]string, 20)
+	for i := range urls {
+		urls[i] = "http://slow.test/" + string(rune('a'+i))
+	}
+	job := m.Start(Request{URLs: urls, Options: Options{Concurrency: 2}})
+
+	start := time.Now()
+	m.Shutdown()
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("shutdown did not cancel in-flight fetches")
+	}
+	if s := job.Status(); s.State != StateCancelled {
+		t.Errorf("got state %q, want %q", s.State, StateCancelled)
+	}
+}
+
+func TestManagerPrunesFinishedJobs(t *testing.T) {
+	m := newTestManager(&fakeFetcher{delay: time.Minute})
+	m.keepFinished = 2
+	defer m.Shutdown()
+
+	running := m.Start(Request{URLs: []string{"http://slow.test/"}})
+	var finished []*Job
+	for i := 0; i < 4; i++ {
+		job := m.Start(Request{})
+		job.Wait()
+		finished = append(finished, job)
+	}
+
+	var ids []string
+	for _, s := range m.List() {
+		ids = append(ids, s.ID)
+	}
+	want := []string{running.ID(), finished[2].ID(), finished[3].ID()}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("got jobs %v, want the running job and the two newest finished ones %v", ids, want)
+	}
+	if _, ok := m.Get(finished[0].ID()); ok {
+		t.Error("expected the oldest finished job to be forgotten")
+	}
+}